@@ -0,0 +1,80 @@
+package expander
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type BenchmarkItem struct {
+	Id        int    `json:"id"`
+	Name      string `json:"name"`
+	Email     string `json:"email,omitempty"`
+	Active    bool   `json:"active"`
+	Country   CountryCode
+	Neighbors []CountryCode `json:"neighbors"`
+	Address   Address       `json:"address"`
+	Tags      []string      `json:"tags"`
+}
+
+func benchmarkItems(count int) []BenchmarkItem {
+	items := make([]BenchmarkItem, count)
+	for i := range items {
+		items[i] = BenchmarkItem{
+			Id:        i,
+			Name:      fmt.Sprintf("item %v", i),
+			Active:    i%2 == 0,
+			Country:   "CH",
+			Neighbors: []CountryCode{"DE", "FR"},
+			Address:   Address{Street: "Bahnhofstrasse", Country: "DE"},
+			Tags:      []string{"a", "b", "c"},
+		}
+	}
+	return items
+}
+
+func benchmarkExpandArray(b *testing.B, resolvers ...Resolver) {
+	ClearResolvers()
+	for _, resolver := range resolvers {
+		AddResolver(resolver)
+	}
+	items := benchmarkItems(1000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ExpandArray(items, "*", "")
+	}
+}
+
+func benchmarkCountries() InMemoryResolver {
+	return NewInMemoryResolver("countries", reflect.TypeOf(CountryCode("")), map[string]interface{}{
+		"CH": map[string]interface{}{"name": "Switzerland"},
+		"DE": map[string]interface{}{"name": "Germany"},
+		"FR": map[string]interface{}{"name": "France"},
+	})
+}
+
+func BenchmarkExpandArrayWithoutResolvers(b *testing.B) {
+	benchmarkExpandArray(b)
+}
+
+func BenchmarkExpandArrayWithTypeFilters(b *testing.B) {
+	benchmarkExpandArray(b,
+		NewMongoDbRefResolver(map[string]string{}, false),
+		NewHALResolver(),
+		benchmarkCountries(),
+	)
+}
+
+// resolvers without TypeFilter are asked for every value, like all resolvers were before the cache
+func BenchmarkExpandArrayWithoutTypeFilters(b *testing.B) {
+	mongo := NewMongoDbRefResolver(map[string]string{}, false)
+	hal := NewHALResolver()
+	countries := benchmarkCountries()
+	benchmarkExpandArray(b,
+		NewResolverFunc(mongo.GetName(), mongo.IsReference, mongo.ResolveRef),
+		NewResolverFunc(hal.GetName(), hal.IsReference, hal.ResolveRef),
+		NewResolverFunc(countries.GetName(), countries.IsReference, countries.ResolveRef),
+	)
+}
@@ -0,0 +1,85 @@
+package expander
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// tagPriority lists the struct tags which name the output keys, the first tag a field has is used.
+var tagPriority = []string{"json"}
+
+// SetTagPriority sets the struct tags which name output keys and define their options, e.g. "json", "bson" to
+// prefer json tags and fall back to bson tags. The first of the tags a field has is used as a whole: its name, "-" and
+// omitempty. The string option is only supported on json tags, bson fields with the inline option are flattened like
+// embedded structs. Untagged fields are named by the key naming.
+func SetTagPriority(tags ...string) {
+	if len(tags) == 0 {
+		tags = []string{"json"}
+	}
+	tagPriority = tags
+}
+
+// fieldTag returns the first tag of the field in order of priority and its name.
+func fieldTag(sf reflect.StructField, tags []string) (string, string) {
+	for _, name := range tags {
+		if tag, ok := sf.Tag.Lookup(name); ok {
+			return tag, name
+		}
+	}
+	return "", ""
+}
+
+func tagPriorityKey(tags []string) string {
+	return strings.Join(tags, ",")
+}
+
+// orderedDocumentTypes maps the registered ordered document types to the indexes of the key and value fields of
+// their elements.
+var (
+	orderedDocumentTypes      = make(map[reflect.Type][2]int)
+	orderedDocumentTypesMutex sync.RWMutex
+)
+
+// RegisterOrderedDocumentType registers a list of key-value pairs like bson.D of the Mongo drivers, e.g.
+// []struct{Name string; Value interface{}} or []struct{Key string; Value interface{}}. Values of registered types are
+// walked like maps and keep their order in ExpandOrdered, other slices of such structs stay arrays.
+func RegisterOrderedDocumentType(t reflect.Type) error {
+	nameIndex, valueIndex, ok := keyValueFields(t)
+	if !ok {
+		return fmt.Errorf("%v is no list of key-value pairs", t)
+	}
+	orderedDocumentTypesMutex.Lock()
+	defer orderedDocumentTypesMutex.Unlock()
+	orderedDocumentTypes[t] = [2]int{nameIndex, valueIndex}
+	return nil
+}
+
+// orderedDocumentFields reports whether the type is a registered ordered document and returns the indexes of the key
+// and value fields of its elements.
+func orderedDocumentFields(t reflect.Type) (int, int, bool) {
+	orderedDocumentTypesMutex.RLock()
+	defer orderedDocumentTypesMutex.RUnlock()
+	fields, ok := orderedDocumentTypes[t]
+	return fields[0], fields[1], ok
+}
+
+// keyValueFields returns the indexes of the key and value fields of the elements of a list of key-value pairs.
+func keyValueFields(t reflect.Type) (int, int, bool) {
+	if t.Kind() != reflect.Slice || t.Elem().Kind() != reflect.Struct || t.Elem().NumField() != 2 {
+		return 0, 0, false
+	}
+	element := t.Elem()
+	nameIndex, valueIndex := -1, -1
+	for i := 0; i < 2; i++ {
+		field := element.Field(i)
+		switch {
+		case (field.Name == "Name" || field.Name == "Key") && field.Type.Kind() == reflect.String:
+			nameIndex = i
+		case field.Name == "Value" && field.Type.Kind() == reflect.Interface:
+			valueIndex = i
+		}
+	}
+	return nameIndex, valueIndex, nameIndex >= 0 && valueIndex >= 0
+}
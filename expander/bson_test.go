@@ -0,0 +1,141 @@
+package expander
+
+import (
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"reflect"
+	"testing"
+)
+
+// element and document types shaped like those of the Mongo drivers
+
+type BsonE struct {
+	Key   string
+	Value interface{}
+}
+
+type BsonD []BsonE
+
+type BsonDocElem struct {
+	Name  string
+	Value interface{}
+}
+
+type BsonM map[string]interface{}
+
+type BsonAudit struct {
+	CreatedBy string `bson:"created_by"`
+	Revision  int    `bson:"revision,omitempty"`
+}
+
+type BsonPost struct {
+	Id       string      `bson:"_id"`
+	Title    string      `bson:"title" json:"headline"`
+	Secret   string      `bson:"-"`
+	Views    int         `bson:"views,omitempty"`
+	Country  CountryCode `bson:"country"`
+	Audit    BsonAudit   `bson:",inline"`
+	Untagged string
+}
+
+func TestBson(t *testing.T) {
+	Convey("It should name keys by the configured tag priority:", t, func() {
+		ClearResolvers()
+		post := BsonPost{Id: "1", Title: "A title", Secret: "s", Country: "CH", Audit: BsonAudit{CreatedBy: "me"}, Untagged: "u"}
+
+		Reset(func() {
+			SetTagPriority("json")
+		})
+
+		Convey("json tags only by default", func() {
+			result := Expand(post, "", "")
+
+			So(result["Id"], ShouldEqual, "1")
+			So(result["headline"], ShouldEqual, "A title")
+			So(result["Secret"], ShouldEqual, "s")
+			So(result["Audit"], ShouldResemble, map[string]interface{}{"CreatedBy": "me", "Revision": int64(0)})
+		})
+
+		Convey("bson tags with their options, inlined structs and untagged fields", func() {
+			SetTagPriority("bson")
+
+			So(Expand(post, "", ""), ShouldResemble, map[string]interface{}{
+				"_id":        "1",
+				"title":      "A title",
+				"country":    "CH",
+				"created_by": "me",
+				"Untagged":   "u",
+			})
+		})
+
+		Convey("the first tag a field has", func() {
+			SetTagPriority("json", "bson")
+			result := Expand(post, "", "")
+
+			So(result["headline"], ShouldEqual, "A title")
+			So(result["_id"], ShouldEqual, "1")
+			So(result, ShouldNotContainKey, "title")
+		})
+	})
+
+	Convey("It should walk registered ordered documents like maps:", t, func() {
+		ClearResolvers()
+		So(RegisterOrderedDocumentType(reflect.TypeOf(BsonD{})), ShouldBeNil)
+		So(RegisterOrderedDocumentType(reflect.TypeOf([]BsonDocElem{})), ShouldBeNil)
+		switzerland := map[string]interface{}{"name": "Switzerland"}
+		AddResolver(NewInMemoryResolver("countries", reflect.TypeOf(CountryCode("")), map[string]interface{}{"CH": switzerland}))
+
+		document := BsonD{
+			{"title", "A title"},
+			{"address", Address{Street: "Bahnhofstrasse", Country: "CH"}},
+			{"tags", []interface{}{"a", BsonM{"nested": true}}},
+			{"author", []BsonDocElem{{"name", "A name"}, {"address", &Address{Country: "CH"}}}},
+		}
+
+		Convey("at the root", func() {
+			So(Expand(document, "*", ""), ShouldResemble, map[string]interface{}{
+				"title":   "A title",
+				"address": map[string]interface{}{"Street": "Bahnhofstrasse", "Country": switzerland, "Neighbors": nil},
+				"tags":    []interface{}{"a", map[string]interface{}{"nested": true}},
+				"author": map[string]interface{}{
+					"name":    "A name",
+					"address": map[string]interface{}{"Street": "", "Country": switzerland, "Neighbors": nil},
+				},
+			})
+		})
+
+		Convey("in fields", func() {
+			result := Expand(KindsHolder{document}, "*", "Value(title,author(name))")
+
+			So(result, ShouldResemble, map[string]interface{}{
+				"Value": map[string]interface{}{
+					"title":  "A title",
+					"author": map[string]interface{}{"name": "A name"},
+				},
+			})
+		})
+
+		Convey("keeping their order in ExpandOrdered", func() {
+			encoded, err := json.Marshal(ExpandOrdered(document, "*", ""))
+
+			So(err, ShouldBeNil)
+			So(string(encoded), ShouldEqual, `{"title":"A title","address":{"Street":"Bahnhofstrasse","Country":{"name":"Switzerland"},"Neighbors":null},`+
+				`"tags":["a",{"nested":true}],"author":{"name":"A name","address":{"Street":"","Country":{"name":"Switzerland"},"Neighbors":null}}}`)
+		})
+
+		Convey("unless the elements are no key-value pairs", func() {
+			So(Expand(KindsHolder{[]BsonAudit{{CreatedBy: "me"}}}, "*", ""), ShouldResemble, map[string]interface{}{
+				"Value": []interface{}{map[string]interface{}{"CreatedBy": "me", "Revision": int64(0)}},
+			})
+			So(RegisterOrderedDocumentType(reflect.TypeOf([]BsonAudit{})), ShouldNotBeNil)
+		})
+
+		Convey("but not lists of key-value pairs which are not registered", func() {
+			pairs := []BsonE{{"title", "A title"}}
+
+			So(Expand(KindsHolder{pairs}, "*", ""), ShouldResemble, map[string]interface{}{
+				"Value": []interface{}{map[string]interface{}{"Key": "title", "Value": "A title"}},
+			})
+		})
+	})
+}
@@ -0,0 +1,183 @@
+package expander
+
+import (
+	"context"
+	"reflect"
+	"sort"
+)
+
+// ChainResolver combines resolvers to a fallback chain, e.g. cache, local database and remote service. A reference is
+// passed to the first resolver of the chain which detects it; references it misses or fails to resolve are passed
+// on to the next one. The order is the order of creation unless priorities are given for a reference type.
+type ChainResolver struct {
+	name       string
+	resolvers  []Resolver
+	priorities map[reflect.Type]map[string]int
+}
+
+// chainReference holds the reference as detected by every resolver of the chain, in the order they are tried.
+type chainReference struct {
+	Resolvers  []int
+	References []Reference
+}
+
+func NewChainResolver(name string, resolvers ...Resolver) ChainResolver {
+	return ChainResolver{name: name, resolvers: resolvers, priorities: make(map[reflect.Type]map[string]int)}
+}
+
+// WithPriority sets the priority of the named resolver for values of the reference type. Resolvers with higher
+// priority are tried first, the default priority is zero.
+func (this ChainResolver) WithPriority(referenceType reflect.Type, resolverName string, priority int) ChainResolver {
+	priorities := make(map[reflect.Type]map[string]int, len(this.priorities)+1)
+	for t, byName := range this.priorities {
+		priorities[t] = byName
+	}
+	byName := make(map[string]int, len(priorities[referenceType])+1)
+	for name, p := range priorities[referenceType] {
+		byName[name] = p
+	}
+	byName[resolverName] = priority
+	priorities[referenceType] = byName
+
+	this.priorities = priorities
+	return this
+}
+
+func (this ChainResolver) IsReference(t reflect.Value) (Reference, bool) {
+	var reference Reference
+	if !t.IsValid() {
+		return reference, false
+	}
+
+	var chain chainReference
+	for _, index := range this.order(t.Type()) {
+		if ref, ok := this.resolvers[index].IsReference(t); ok {
+			chain.Resolvers = append(chain.Resolvers, index)
+			chain.References = append(chain.References, ref)
+		}
+	}
+	if len(chain.References) == 0 {
+		return reference, false
+	}
+
+	reference.Id = chain.References[0].Id
+	reference.OriginalReference = chain
+	return reference, true
+}
+
+func (this ChainResolver) MayBeReference(t reflect.Type) bool {
+	for _, resolver := range this.resolvers {
+		if filter, ok := resolver.(TypeFilter); !ok || filter.MayBeReference(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this ChainResolver) GetName() string {
+	return this.name
+}
+
+func (this ChainResolver) ResolveRef(refs []Reference) map[string]interface{} {
+	return this.ResolveRefContext(context.Background(), refs)
+}
+
+// ResolveRefContext passes the context on to the resolvers of the chain which take one.
+func (this ChainResolver) ResolveRefContext(ctx context.Context, refs []Reference) map[string]interface{} {
+	result := make(map[string]interface{})
+	pending := refs
+
+	for len(pending) > 0 {
+		ready := this.readyResolvers(pending)
+
+		// group the next try of every pending reference by resolver, keeping the order of the chain per reference
+		perResolver := make(map[int][]Reference)
+		origins := make(map[int]map[string][]Reference)
+		var next []Reference
+		for _, ref := range pending {
+			chain := ref.OriginalReference.(chainReference)
+			if len(chain.Resolvers) == 0 {
+				continue
+			}
+			index := chain.Resolvers[0]
+			if !ready[index] {
+				next = append(next, ref)
+				continue
+			}
+			inner := chain.References[0]
+			inner.Document = ref.Document
+			perResolver[index] = append(perResolver[index], inner)
+			if origins[index] == nil {
+				origins[index] = make(map[string][]Reference)
+			}
+			origins[index][inner.Id] = append(origins[index][inner.Id], ref)
+		}
+
+		for index, innerRefs := range perResolver {
+			resolved := resolveRef(ctx, this.resolvers[index], innerRefs)
+			for id, refs := range origins[index] {
+				value, ok := resolved[id]
+				if _, isError := value.(error); ok && !isError {
+					for _, ref := range refs {
+						result[ref.Id] = value
+					}
+					continue
+				}
+				for _, ref := range refs {
+					if ok {
+						result[ref.Id] = value
+					}
+					chain := ref.OriginalReference.(chainReference)
+					ref.OriginalReference = chainReference{Resolvers: chain.Resolvers[1:], References: chain.References[1:]}
+					next = append(next, ref)
+				}
+			}
+		}
+		pending = next
+	}
+	return result
+}
+
+// readyResolvers returns the resolvers which are next for some references and can not be reached later by others,
+// so every resolver is called with as few batches as possible. If the chains contradict each other all are ready.
+func (this ChainResolver) readyResolvers(pending []Reference) map[int]bool {
+	heads := make(map[int]bool)
+	later := make(map[int]bool)
+	for _, ref := range pending {
+		chain := ref.OriginalReference.(chainReference)
+		for position, index := range chain.Resolvers {
+			if position == 0 {
+				heads[index] = true
+			} else {
+				later[index] = true
+			}
+		}
+	}
+
+	ready := make(map[int]bool)
+	for index := range heads {
+		if !later[index] {
+			ready[index] = true
+		}
+	}
+	if len(ready) == 0 {
+		return heads
+	}
+	return ready
+}
+
+// order returns the indexes of the resolvers in the order they are tried for values of the given type.
+func (this ChainResolver) order(referenceType reflect.Type) []int {
+	indexes := make([]int, len(this.resolvers))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	priorities := this.priorities[referenceType]
+	if len(priorities) == 0 {
+		return indexes
+	}
+	sort.SliceStable(indexes, func(a, b int) bool {
+		return priorities[this.resolvers[indexes[a]].GetName()] > priorities[this.resolvers[indexes[b]].GetName()]
+	})
+	return indexes
+}
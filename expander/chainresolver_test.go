@@ -0,0 +1,94 @@
+package expander
+
+import (
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"reflect"
+	"testing"
+)
+
+type UserId string
+
+type GroupId string
+
+type Membership struct {
+	User  UserId
+	Group GroupId
+	Users []UserId
+}
+
+func TestChainResolver(t *testing.T) {
+	Convey("It should pass references missed by one resolver on to the next one:", t, func() {
+		ClearResolvers()
+		userType := reflect.TypeOf(UserId(""))
+		groupType := reflect.TypeOf(GroupId(""))
+		cache := NewInMemoryResolver("cache", userType, map[string]interface{}{"1": "cached user 1"})
+		var remoteCalls [][]Reference
+		detectAny := func(v reflect.Value) (Reference, bool) {
+			if v.Type() != userType && v.Type() != groupType {
+				return Reference{}, false
+			}
+			return Reference{Id: v.String(), OriginalReference: v.Interface()}, true
+		}
+		remote := NewResolverFunc("remote", detectAny, func(refs []Reference) map[string]interface{} {
+			remoteCalls = append(remoteCalls, refs)
+			result := make(map[string]interface{})
+			for _, ref := range refs {
+				if ref.Id == "broken" {
+					result[ref.Id] = errors.New("remote failure")
+				} else {
+					result[ref.Id] = "remote " + ref.Id
+				}
+			}
+			return result
+		})
+
+		Convey("Misses of the cache should be resolved by the remote resolver in one batch", func() {
+			AddResolver(NewChainResolver("users", cache, remote))
+			membership := Membership{User: "1", Group: "g", Users: []UserId{"1", "2", "3"}}
+
+			result := Expand(membership, "*", "")
+
+			So(result["User"], ShouldEqual, "cached user 1")
+			So(result["Group"], ShouldEqual, "remote g")
+			So(result["Users"], ShouldResemble, []interface{}{"cached user 1", "remote 2", "remote 3"})
+			So(len(remoteCalls), ShouldEqual, 1)
+			So(len(remoteCalls[0]), ShouldEqual, 3)
+		})
+
+		Convey("Errors of the last resolver should take the error path", func() {
+			AddResolver(NewChainResolver("users", cache, remote))
+			SetErrorPolicy(ErrorObject)
+
+			result := Expand(Membership{User: "broken"}, "User", "")
+
+			So(result["User"].(map[string]interface{})["error"], ShouldEqual, "remote failure")
+			SetErrorPolicy(KeepReference)
+		})
+
+		Convey("Ids tagged with the chain should be detected and resolved by the chain", func() {
+			AddResolver(NewChainResolver("users", cache, remote))
+
+			result := Expand(TaggedPost{AuthorId: "1", EditorIds: []UserId{"1", "2"}}, "*", "")
+
+			So(result["authorId"], ShouldEqual, "1")
+			So(result["author"], ShouldEqual, "cached user 1")
+			So(result["editors"], ShouldResemble, []interface{}{"cached user 1", "remote 2"})
+		})
+
+		Convey("Priorities should change the order per reference type", func() {
+			chain := NewChainResolver("users", cache, remote).WithPriority(userType, "remote", 1)
+			AddResolver(chain)
+
+			result := Expand(Membership{User: "1"}, "User", "")
+
+			So(result["User"], ShouldEqual, "remote 1")
+			So(chain.order(groupType), ShouldResemble, []int{0, 1})
+			So(chain.order(userType), ShouldResemble, []int{1, 0})
+		})
+
+		Reset(func() {
+			ClearResolvers()
+		})
+	})
+}
@@ -1,12 +1,17 @@
 package expander
 
 import (
+	"context"
+	"encoding"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // TODO:
@@ -14,17 +19,112 @@ import (
 // 2. fix other TODOs
 const (
 	COLLECTION_KEY = "Collection"
-	emptyTimeValue = "0001-01-01T00:00:00Z"
 )
 
-var resolvers []Resolver
+// ErrorPolicy defines what is written in place of a reference that could not be resolved.
+type ErrorPolicy int
 
+const (
+	// KeepReference leaves the original reference untouched.
+	KeepReference ErrorPolicy = iota
+	// NullOnError replaces the reference with null.
+	NullOnError
+	// ErrorObject replaces the reference with an object describing the failure.
+	ErrorObject
+)
+
+var ErrNotResolved = errors.New("reference could not be resolved")
+var ErrTimeout = errors.New("reference was not resolved in time")
+var ErrNotDetected = errors.New("value is not a reference of the tagged resolver")
+
+var registry = NewRegistry()
+var errorPolicy = KeepReference
+var resolverTimeouts = make(map[string]time.Duration)
+var resolverTimeoutsMutex sync.RWMutex
+var expansionTimeout time.Duration
+
+// AddResolver registers the resolver, replacing a registered resolver with the same name. Resolvers of the same type
+// share their name, so e.g. a second MongoDbRefResolver replaces the first one together with its URIs instead of
+// adding to them. RegisterResolver refuses taken names instead.
 func AddResolver(newResolver Resolver) {
-	resolvers = append(resolvers, newResolver)
+	registry.Put(newResolver)
+}
+
+// RegisterResolver registers the resolver and fails with a DuplicateResolverError if its name is taken.
+func RegisterResolver(newResolver Resolver) error {
+	return registry.Register(newResolver)
+}
+
+// ReplaceResolver replaces the registered resolver with the same name and fails with an UnknownResolverError if
+// there is none.
+func ReplaceResolver(newResolver Resolver) error {
+	return registry.Replace(newResolver)
+}
+
+func RemoveResolver(name string) error {
+	return registry.Remove(name)
+}
+
+func GetResolver(name string) (Resolver, bool) {
+	return registry.Get(name)
+}
+
+func ListResolvers() []Resolver {
+	return registry.List()
 }
 
 func ClearResolvers() {
-	resolvers = []Resolver{}
+	registry.Clear()
+}
+
+func SetErrorPolicy(policy ErrorPolicy) {
+	errorPolicy = policy
+}
+
+// SetResolverTimeout limits the time a single call of the named resolver may take. Zero removes the limit.
+func SetResolverTimeout(resolverName string, timeout time.Duration) {
+	resolverTimeoutsMutex.Lock()
+	defer resolverTimeoutsMutex.Unlock()
+	if timeout <= 0 {
+		delete(resolverTimeouts, resolverName)
+		return
+	}
+	resolverTimeouts[resolverName] = timeout
+}
+
+// SetExpansionTimeout limits the time resolvers may take in total during one call of Expand or ExpandArray.
+// References which are not resolved in time take the error path. Zero removes the limit.
+func SetExpansionTimeout(timeout time.Duration) {
+	expansionTimeout = timeout
+}
+
+func resolverTimeout(resolverName string) (time.Duration, bool) {
+	resolverTimeoutsMutex.RLock()
+	defer resolverTimeoutsMutex.RUnlock()
+	timeout, ok := resolverTimeouts[resolverName]
+	return timeout, ok
+}
+
+func expansionDeadline() time.Time {
+	if expansionTimeout <= 0 {
+		return time.Time{}
+	}
+	return now().Add(expansionTimeout)
+}
+
+// failedValue returns the value which replaces an unresolvable reference according to the error policy.
+func failedValue(original interface{}, err error) interface{} {
+	switch errorPolicy {
+	case NullOnError:
+		return nil
+	case ErrorObject:
+		return map[string]interface{}{
+			"error":     err.Error(),
+			"reference": original,
+		}
+	default:
+		return original
+	}
 }
 
 func resolveFilters(expansion, fields string) (expansionFilter Filters, fieldFilter Filters, recursiveExpansion bool, err error) {
@@ -49,8 +149,17 @@ func resolveFilters(expansion, fields string) (expansionFilter Filters, fieldFil
 	return
 }
 
-//TODO: TagFields & BSONFields
+// Expand walks the data, which may be a struct, a map or an ordered document like bson.D, and replaces the references
+// selected by expansion with the resolved values. Output keys are named by the struct tags set with SetTagPriority.
 func Expand(data interface{}, expansion, fields string) map[string]interface{} {
+	result, _ := ExpandWithReport(data, expansion, fields)
+	return result
+}
+
+// ExpandWithReport works like Expand and additionally reports the references which could not be resolved in time.
+func ExpandWithReport(data interface{}, expansion, fields string) (map[string]interface{}, ExpansionReport) {
+	var report ExpansionReport
+	deadline := expansionDeadline()
 
 	expansionFilter, fieldFilter, recursiveExpansion, err := resolveFilters(expansion, fields)
 	if err != nil {
@@ -59,17 +168,28 @@ func Expand(data interface{}, expansion, fields string) map[string]interface{} {
 		fmt.Printf("Warning: Filter was not correct, expansionFilter: '%v' fieldFilter: '%v', error: %v \n", expansion, fields, err)
 	}
 
-	resolveTasks := []ExpansionTask{}
-	walkStateHolder := WalkStateHolder{&resolveTasks}
+	resolvers := registry.snapshot()
+	walkStateHolder := newWalkStateHolder(data, resolvers)
 	expanded := walkByExpansion(data, walkStateHolder, expansionFilter, recursiveExpansion)
-	executeExpansionTasks(walkStateHolder.GetExpansionTasks(), recursiveExpansion)
+	report.TimedOut = executeExpansionTasks(walkStateHolder.GetExpansionTasks(), resolvers.resolvers, deadline)
+	expanded = applyKeyStrategy(expanded)
 
 	filtered := walkByFilter(expanded, fieldFilter)
 
-	return filtered
+	return filtered, report
 }
 
 func ExpandArray(data interface{}, expansion, fields string) []interface{} {
+	result, _ := ExpandArrayWithReport(data, expansion, fields)
+	return result
+}
+
+// ExpandArrayWithReport works like ExpandArray and additionally reports the references which could not be
+// resolved in time.
+func ExpandArrayWithReport(data interface{}, expansion, fields string) ([]interface{}, ExpansionReport) {
+	var report ExpansionReport
+	deadline := expansionDeadline()
+
 	expansionFilter, fieldFilter, recursiveExpansion, err := resolveFilters(expansion, fields)
 	if err != nil {
 		expansionFilter = Filters{}
@@ -80,7 +200,7 @@ func ExpandArray(data interface{}, expansion, fields string) []interface{} {
 	var result []interface{}
 
 	if data == nil {
-		return result
+		return result, report
 	}
 
 	v := reflect.ValueOf(data)
@@ -89,44 +209,157 @@ func ExpandArray(data interface{}, expansion, fields string) []interface{} {
 		v = data.(reflect.Value)
 	}
 
-	if v.Kind() != reflect.Slice {
-		return result
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return result, report
 	}
 
-	v = v.Slice(0, v.Len())
+	resolvers := registry.snapshot()
 	for i := 0; i < v.Len(); i++ {
-		resolveTasks := []ExpansionTask{}
-		walkStateHolder := WalkStateHolder{&resolveTasks}
-		arrayItem := walkByExpansion(v.Index(i), walkStateHolder, expansionFilter, recursiveExpansion)
-		executeExpansionTasks(walkStateHolder.GetExpansionTasks(), recursiveExpansion)
-		arrayItem = walkByFilter(arrayItem, fieldFilter)
-		result = append(result, arrayItem)
+		items, timedOut := expandChunk(v, i, i+1, expansionFilter, fieldFilter, recursiveExpansion, resolvers, deadline)
+		report.TimedOut = append(report.TimedOut, timedOut...)
+		result = append(result, items[0])
 	}
-	return result
+	return result, report
 }
 
-func executeExpansionTasks(expansionTasks []ExpansionTask, recursive bool) {
-	tasksByResolver := make(map[string][]ExpansionTask)
-	for _, task := range expansionTasks {
-		tasksByResolver[task.Resolver] = expansionTasks
+type resolverCall struct {
+	index  int
+	result map[string]interface{}
+}
+
+// ResolverPanicError is the error of the references of a resolver which panicked.
+type ResolverPanicError struct {
+	Name  string
+	Value interface{}
+}
+
+func (this ResolverPanicError) Error() string {
+	return fmt.Sprintf("resolver '%v' panicked: %v", this.Name, this.Value)
+}
+
+// executeExpansionTasks resolves the tasks with the given resolvers in parallel and returns the references which timed
+// out. Results are applied in the order of the resolvers, results arriving after the timeout are discarded.
+func executeExpansionTasks(expansionTasks []ExpansionTask, resolvers []Resolver, deadline time.Time) []Reference {
+	tasksByResolver := make(map[string][]int)
+	for i, task := range expansionTasks {
+		tasksByResolver[task.Resolver] = append(tasksByResolver[task.Resolver], i)
 	}
 
-	for _, resolver := range resolvers {
-		tasks := tasksByResolver[resolver.GetName()]
+	results := make([]map[string]interface{}, len(resolvers))
+	timedOut := make([]bool, len(resolvers))
+	deadlines := make(map[int]time.Time)
+	calls := make(chan resolverCall, len(resolvers))
+	for index, resolver := range resolvers {
+		taskIndexes := tasksByResolver[resolver.GetName()]
+		if len(taskIndexes) == 0 {
+			continue
+		}
+		resolverDeadline := deadline
+		if timeout, ok := resolverTimeout(resolver.GetName()); ok {
+			if resolverDeadline.IsZero() || now().Add(timeout).Before(resolverDeadline) {
+				resolverDeadline = now().Add(timeout)
+			}
+		}
+		if !resolverDeadline.IsZero() && !now().Before(resolverDeadline) {
+			timedOut[index] = true
+			continue
+		}
+		deadlines[index] = resolverDeadline
+
 		var refs []Reference
-		for _, task := range tasks {
-			refs = append(refs, task.Reference)
-		}
-		result := resolver.ResolveRef(refs)
-		for _, task := range tasks {
-			if value, ok := result[task.Reference.Id]; ok {
-				task.Success(value)
-			} else if task.Error != nil {
-				task.Error()
+		for _, i := range taskIndexes {
+			refs = append(refs, expansionTasks[i].Reference)
+		}
+		// resolvers which take a context stop when their deadline passes instead of running on abandoned
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if resolverDeadline.IsZero() {
+			ctx, cancel = context.WithCancel(context.Background())
+		} else {
+			ctx, cancel = context.WithDeadline(context.Background(), resolverDeadline)
+		}
+		defer cancel()
+		go func(ctx context.Context, index int, resolver Resolver, refs []Reference) {
+			// a panicking resolver fails its references instead of taking the whole process down
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					err := ResolverPanicError{Name: resolver.GetName(), Value: recovered}
+					result := make(map[string]interface{}, len(refs))
+					for _, ref := range refs {
+						result[ref.Id] = err
+					}
+					calls <- resolverCall{index, result}
+				}
+			}()
+			calls <- resolverCall{index, resolveRef(ctx, resolver, refs)}
+		}(ctx, index, resolver, refs)
+	}
+
+	for len(deadlines) > 0 {
+		var earliest time.Time
+		for _, resolverDeadline := range deadlines {
+			if !resolverDeadline.IsZero() && (earliest.IsZero() || resolverDeadline.Before(earliest)) {
+				earliest = resolverDeadline
+			}
+		}
+		var timer <-chan time.Time
+		if !earliest.IsZero() {
+			timer = time.After(earliest.Sub(now()))
+		}
+
+		select {
+		case call := <-calls:
+			if _, waiting := deadlines[call.index]; waiting {
+				results[call.index] = call.result
+				delete(deadlines, call.index)
+			}
+		case <-timer:
+			for index, resolverDeadline := range deadlines {
+				if !resolverDeadline.IsZero() && !now().Before(resolverDeadline) {
+					timedOut[index] = true
+					delete(deadlines, index)
+				}
 			}
 		}
+	}
 
+	resolved := make([]bool, len(expansionTasks))
+	failures := make([]error, len(expansionTasks))
+	for index, resolver := range resolvers {
+		for _, i := range tasksByResolver[resolver.GetName()] {
+			if timedOut[index] {
+				if failures[i] == nil {
+					failures[i] = ErrTimeout
+				}
+				continue
+			}
+			value, ok := results[index][expansionTasks[i].Reference.Id]
+			if err, isError := value.(error); isError {
+				failures[i] = err
+			} else if ok {
+				resolved[i] = true
+				expansionTasks[i].Success(keyNaming.renameResolved(value))
+			}
+		}
 	}
+
+	var timedOutRefs []Reference
+	for i, task := range expansionTasks {
+		if resolved[i] {
+			continue
+		}
+		err := failures[i]
+		if err == nil {
+			err = ErrNotResolved
+		}
+		if err == ErrTimeout {
+			timedOutRefs = append(timedOutRefs, task.Reference)
+		}
+		if task.Error != nil {
+			task.Error(err)
+		}
+	}
+	return timedOutRefs
 }
 
 func walkByFilter(data map[string]interface{}, filters Filters) map[string]interface{} {
@@ -138,50 +371,89 @@ func walkByFilter(data map[string]interface{}, filters Filters) map[string]inter
 
 	for k, v := range data {
 		if filters.IsEmpty() || filters.Contains(k) {
-			ft := reflect.ValueOf(v)
+			result[k] = filterValue(v, filters.Get(k).Children)
+		}
+	}
 
-			result[k] = v
-			subFilters := filters.Get(k).Children
+	return result
+}
 
-			if v == nil {
-				continue
+// filterValue applies the filters to the objects in a value of any shape: maps, ordered maps and structs, also inside
+// slices, arrays, pointers and interfaces. Without filters objects of other types than the walked ones are kept as
+// they are.
+func filterValue(value interface{}, filters Filters) interface{} {
+	switch value := value.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		return walkByFilter(value, filters)
+	case OrderedMap:
+		return value.filter(filters)
+	case []map[string]interface{}:
+		children := make([]map[string]interface{}, len(value))
+		for i, child := range value {
+			if child != nil {
+				children[i] = walkByFilter(child, filters)
 			}
+		}
+		return children
+	case []interface{}:
+		children := make([]interface{}, len(value))
+		for i, child := range value {
+			children[i] = filterValue(child, filters)
+		}
+		return children
+	}
 
-			switch ft.Type().Kind() {
-			case reflect.Map:
-				result[k] = walkByFilter(v.(map[string]interface{}), subFilters)
-			case reflect.Slice:
-				if ft.Len() == 0 {
-					continue
-				}
+	if filters.IsEmpty() {
+		return value
+	}
+	v := reflect.ValueOf(value)
+	if decoded, ok := marshaledValue(v); ok {
+		return filterValue(decoded, filters)
+	}
 
-				switch ft.Index(0).Kind() {
-				case reflect.Map:
-					children := make([]map[string]interface{}, 0)
-					for _, child := range v.([]map[string]interface{}) {
-						item := walkByFilter(child, subFilters)
-						children = append(children, item)
-					}
-					result[k] = children
-				default:
-					children := make([]interface{}, 0)
-					for _, child := range v.([]interface{}) {
-						cft := reflect.TypeOf(child)
-
-						if cft.Kind() == reflect.Map {
-							item := walkByFilter(child.(map[string]interface{}), subFilters)
-							children = append(children, item)
-						} else {
-							children = append(children, child)
-						}
-					}
-					result[k] = children
-				}
+	switch v.Kind() {
+	case reflect.Map:
+		if v.IsNil() {
+			return value
+		}
+		result := make(map[string]interface{})
+		for _, key := range v.MapKeys() {
+			k, ok := mapKey(key)
+			if ok && filters.Contains(k) {
+				result[k] = filterValue(v.MapIndex(key).Interface(), filters.Get(k).Children)
 			}
 		}
+		return result
+	case reflect.Slice, reflect.Array:
+		if (v.Kind() == reflect.Slice && v.IsNil()) || v.Type().Elem().Kind() == reflect.Uint8 {
+			return value
+		}
+		children := make([]interface{}, v.Len())
+		for i := range children {
+			children[i] = filterValue(v.Index(i).Interface(), filters)
+		}
+		return children
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return value
+		}
+		return filterValue(v.Elem().Interface(), filters)
+	case reflect.Struct:
+		// structs returned by resolvers are filtered in the shape they are encoded in
+		bytes, err := json.Marshal(value)
+		var decoded interface{}
+		if err == nil {
+			err = json.Unmarshal(bytes, &decoded)
+		}
+		if err != nil {
+			fmt.Printf("Warning: could not filter %v: %v \n", v.Type(), err)
+			return value
+		}
+		return filterValue(decoded, filters)
 	}
-
-	return result
+	return value
 }
 
 func walkByExpansion(data interface{}, walkStateHolder WalkStateHolder, filters Filters, recursive bool) map[string]interface{} {
@@ -196,13 +468,16 @@ func walkByExpansion(data interface{}, walkStateHolder WalkStateHolder, filters
 	case reflect.Value:
 		v = data.(reflect.Value)
 	}
-	if v.Type().Kind() == reflect.Ptr {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return result
+		}
 		v = v.Elem()
 	}
 
 	//	var resultWriteMutex = sync.Mutex{}
-	var writeToResult = func(key string, value interface{}, omitempty bool) {
-		if omitempty && isEmptyValue(reflect.ValueOf(value)) {
+	var writeToResult = func(key string, value interface{}, omit bool) {
+		if omit {
 			delete(result, key)
 		} else {
 			result[key] = value
@@ -210,7 +485,7 @@ func walkByExpansion(data interface{}, walkStateHolder WalkStateHolder, filters
 	}
 
 	// check if root is db ref
-	reference, resolver, ok := testForReferences(v)
+	reference, resolver, ok := walkStateHolder.resolvers.testForReferences(v)
 	if ok && recursive {
 		placeholder := make(map[string]interface{})
 
@@ -218,108 +493,290 @@ func walkByExpansion(data interface{}, walkStateHolder WalkStateHolder, filters
 		resolveTask.Reference = reference
 		resolveTask.Resolver = resolver.GetName()
 		resolveTask.Success = func(value interface{}) {
-			valueAsMap := value.(map[string]interface{})
+			if !keyStrategy.replaces() {
+				original := walkByExpansion(v, newWalkStateHolder(nil, walkStateHolder.resolvers), Filters{}, false)
+				for k, v := range original {
+					placeholder[k] = v
+				}
+				placeholder[keyStrategy.rootNestKey()] = value
+				return
+			}
+			// only objects can replace the root
+			valueAsMap, _ := value.(map[string]interface{})
+			if ordered, ok := value.(OrderedMap); ok {
+				valueAsMap = ordered.Values
+			}
 			for k, v := range valueAsMap {
 				placeholder[k] = v
 			}
 		}
+		resolveTask.Error = func(err error) {
+			// the root has to stay an object, so null leaves the placeholder empty
+			original := walkByExpansion(v, newWalkStateHolder(nil, walkStateHolder.resolvers), Filters{}, false)
+			if !keyStrategy.replaces() {
+				for k, v := range original {
+					placeholder[k] = v
+				}
+				if errorPolicy != KeepReference {
+					placeholder[keyStrategy.rootNestKey()] = failedValue(original, err)
+				}
+				return
+			}
+			if valueAsMap, ok := failedValue(original, err).(map[string]interface{}); ok {
+				for k, v := range valueAsMap {
+					placeholder[k] = v
+				}
+			}
+		}
 		walkStateHolder.AddExpansionTask(resolveTask)
 		return placeholder
 	}
 
-	for i := 0; i < v.NumField(); i++ {
-		f := v.Field(i)
-		ft := v.Type().Field(i)
-
-		if f.Kind() == reflect.Ptr {
-			f = f.Elem()
+	// decoded JSON and BSON documents
+	for v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	if _, _, ok := orderedDocumentFields(v.Type()); v.Kind() == reflect.Map || ok {
+		options := func() (bool, string) {
+			return recursive, ""
 		}
-		var omitempty = false
+		document, _ := getValue(v, walkStateHolder, filters, options).(map[string]interface{})
+		return document
+	}
+	if v.Kind() != reflect.Struct {
+		return result
+	}
 
-		key := ft.Name
-		tag := ft.Tag.Get("json")
-		if tag != "" {
-			tags := strings.Split(tag, ",")
-			key = tags[0]
-			for _, currentPart := range tags {
-				if currentPart == "omitempty" {
-					omitempty = true
-				}
-			}
+	for _, field := range cachedTypeFields(v.Type()) {
+		fieldValue, ok := fieldByIndex(v, field.index)
+		if !ok {
+			continue
 		}
 
+		f := indirect(fieldValue)
+		key, omitempty := field.name, field.omitempty
+		omit := omitempty && isEmptyValue(fieldValue)
+
 		options := func() (bool, string) {
 			return recursive, key
 		}
 
-		reference, resolver, ok := testForReferences(f)
-		if ok {
+		// tagged fields are expanded by the resolver of the tag only
+		var reference Reference
+		var resolver Resolver
+		var detected bool
+		if !field.hasExpand {
+			reference, resolver, detected = walkStateHolder.resolvers.testForReferences(f)
+		}
+		if !f.IsValid() {
+			writeToResult(key, nil, omit)
+		} else if detected {
 			if filters.Contains(key) || recursive {
 
 				var resolveTask ExpansionTask
 				resolveTask.Reference = reference
 				resolveTask.Resolver = resolver.GetName()
 				resolveTask.Success = func(value interface{}) {
-					writeToResult(key, value, omitempty)
+					result[key] = expandedValue{original: f.Interface(), value: value, omitempty: omitempty}
 				}
-				resolveTask.Error = func() {
-					writeToResult(key, f.Interface(), omitempty)
+				resolveTask.Error = func(err error) {
+					result[key] = expandedValue{original: f.Interface(), err: err, omitempty: omitempty}
 				}
 				walkStateHolder.AddExpansionTask(resolveTask)
 
 			} else {
-				writeToResult(key, f.Interface(), omitempty)
+				writeToResult(key, f.Interface(), omit)
 			}
+		} else if field.hasExpand {
+			writeToResult(key, getValue(f, walkStateHolder, Filters{}, func() (bool, string) {
+				return false, key
+			}), omit)
 		} else {
 			val := getValue(f, walkStateHolder, filters, options)
-			writeToResult(key, val, omitempty)
-			switch val.(type) {
-			case string:
-				unquoted, err := strconv.Unquote(val.(string))
-				if err == nil {
-					writeToResult(key, unquoted, omitempty)
-				}
+			if field.quoted {
+				val = quoteValue(val)
 			}
+			writeToResult(key, val, omit)
 		}
 
+		if field.hasExpand {
+			expandTag := field.expand
+			expandKey := expandTag.Key
+			if expandKey == "" {
+				expandKey = key
+			}
+			if filters.Contains(expandKey) || recursive {
+				addTaggedExpansionTasks(f, expandTag.Resolver, walkStateHolder, expandKey != key, func(value interface{}) {
+					writeToResult(expandKey, value, false)
+				})
+			}
+		}
 	}
 
 	return result
 }
 
-func testForReferences(value reflect.Value) (Reference, Resolver, bool) {
-	var ref Reference
-	for _, resolver := range resolvers {
-		if ref, ok := resolver.IsReference(value); ok {
-			return ref, resolver, true
+type expandTag struct {
+	Key      string
+	Resolver string
+}
+
+// parseExpandTag parses tags of the form `expand:"author,resolver=users"`. The key defaults to the key of the field.
+func parseExpandTag(tag string) (expandTag, bool) {
+	var result expandTag
+	if tag == "" {
+		return result, false
+	}
+	parts := strings.Split(tag, ",")
+	result.Key = parts[0]
+	for _, part := range parts[1:] {
+		if strings.HasPrefix(part, "resolver=") {
+			result.Resolver = strings.TrimPrefix(part, "resolver=")
+		}
+	}
+	return result, result.Resolver != ""
+}
+
+// quoteValue encodes the value as JSON string, like encoding/json does for fields with the string option.
+func quoteValue(value interface{}) interface{} {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	return string(bytes)
+}
+
+// addTaggedExpansionTasks creates expansion tasks for an id field, or a slice of ids, which is tagged with the resolver
+// to use. The references are detected by that resolver; ids it does not detect take the error path. Empty ids are not
+// expanded. When the expanded values go to a key of their own, ids which fail under KeepReference are not copied
+// there, they are kept under the key of the id already.
+func addTaggedExpansionTasks(f reflect.Value, resolverName string, walkStateHolder WalkStateHolder, ownKey bool, write func(value interface{})) {
+	if !f.IsValid() {
+		return
+	}
+	resolver := walkStateHolder.resolvers.byName(resolverName)
+	newTask := func(id reflect.Value, success func(value interface{}), failure func(err error)) {
+		if resolver == nil {
+			failure(UnknownResolverError{resolverName})
+			return
+		}
+		reference, ok := resolver.IsReference(id)
+		if !ok {
+			failure(ErrNotDetected)
+			return
+		}
+		var resolveTask ExpansionTask
+		resolveTask.Reference = reference
+		resolveTask.Resolver = resolverName
+		resolveTask.Success = success
+		resolveTask.Error = failure
+		walkStateHolder.AddExpansionTask(resolveTask)
+	}
+
+	if f.Kind() != reflect.Slice && f.Kind() != reflect.Array {
+		if isEmptyValue(f) {
+			return
 		}
+		newTask(f, write, func(err error) {
+			if !ownKey || errorPolicy != KeepReference {
+				write(failedValue(f.Interface(), err))
+			}
+		})
+		return
+	}
+
+	if !ownKey || errorPolicy != KeepReference {
+		expanded := make([]interface{}, f.Len())
+		for i := 0; i < f.Len(); i++ {
+			id := f.Index(i)
+			localCounter := i
+			expanded[i] = id.Interface()
+			newTask(id, func(value interface{}) {
+				expanded[localCounter] = value
+			}, func(err error) {
+				expanded[localCounter] = failedValue(id.Interface(), err)
+			})
+		}
+		write(expanded)
+		return
+	}
+
+	// every task gets exactly one callback, the resolved values are written once the last one is in
+	values := make([]interface{}, f.Len())
+	resolved := make([]bool, f.Len())
+	pending := f.Len()
+	done := func() {
+		pending--
+		if pending > 0 {
+			return
+		}
+		expanded := []interface{}{}
+		for i, value := range values {
+			if resolved[i] {
+				expanded = append(expanded, value)
+			}
+		}
+		if len(expanded) > 0 {
+			write(expanded)
+		}
+	}
+	for i := 0; i < f.Len(); i++ {
+		localCounter := i
+		newTask(f.Index(i), func(value interface{}) {
+			values[localCounter] = value
+			resolved[localCounter] = true
+			done()
+		}, func(err error) {
+			done()
+		})
 	}
-	return ref, nil, false
 }
 
 func getValue(t reflect.Value, walkStateHolder WalkStateHolder, filters Filters, options func() (bool, string)) interface{} {
 	recursive, parentKey := options()
 
+	if value, ok := marshaledValue(t); ok {
+		return value
+	}
+
 	switch t.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		return t.Int()
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
 		return t.Uint()
-	case reflect.Float32, reflect.Float64:
+	case reflect.Float32:
+		return float32(t.Float())
+	case reflect.Float64:
 		return t.Float()
 	case reflect.Bool:
 		return t.Bool()
 	case reflect.String:
 		return t.String()
-	case reflect.Slice:
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.IsNil() {
+			return nil
+		}
+		if nameIndex, valueIndex, ok := orderedDocumentFields(t.Type()); ok {
+			result := make(map[string]interface{})
+			entryFilters, selected := documentFilters(filters, options)
+			for i := 0; i < t.Len(); i++ {
+				element := t.Index(i)
+				walkDocumentEntry(result, element.Field(nameIndex).String(), element.Field(valueIndex), walkStateHolder, entryFilters, selected, options)
+			}
+			return result
+		}
+		if t.Kind() == reflect.Slice && t.Type().Elem().Kind() == reflect.Uint8 {
+			return base64.StdEncoding.EncodeToString(t.Bytes())
+		}
 		var result = []interface{}{}
 
+		// elements stay under the key of the slice, structs and maps among them descend the filters by it like fields
 		for i := 0; i < t.Len(); i++ {
-			current := t.Index(i)
+			current := indirect(t.Index(i))
 
 			if filters.Contains(parentKey) || recursive {
 
-				reference, resolver, ok := testForReferences(current)
+				reference, resolver, ok := walkStateHolder.resolvers.testForReferences(current)
 				if ok {
 					result = append(result, current.Interface())
 
@@ -328,39 +785,49 @@ func getValue(t reflect.Value, walkStateHolder WalkStateHolder, filters Filters,
 					resolveTask.Reference = reference
 					resolveTask.Resolver = resolver.GetName()
 					resolveTask.Success = func(resolvedValue interface{}) {
-						result[localCounter] = resolvedValue
+						result[localCounter] = expandedValue{original: current.Interface(), value: resolvedValue}
+					}
+					resolveTask.Error = func(err error) {
+						result[localCounter] = expandedValue{original: current.Interface(), err: err}
 					}
 					walkStateHolder.AddExpansionTask(resolveTask)
 
 				} else {
-					result = append(result, getValue(current, walkStateHolder, filters.Get(parentKey).Children, options))
+					result = append(result, getValue(current, walkStateHolder, filters, options))
 				}
 			} else {
-				result = append(result, getValue(current, walkStateHolder, filters.Get(parentKey).Children, options))
+				result = append(result, getValue(current, walkStateHolder, filters, options))
 			}
 		}
 
 		return result
 	case reflect.Map:
+		if t.IsNil() {
+			return nil
+		}
 		result := make(map[string]interface{})
+		entryFilters, selected := documentFilters(filters, options)
 
 		for _, v := range t.MapKeys() {
-			key := v.Interface().(string)
-			result[key] = getValue(t.MapIndex(v), walkStateHolder, filters.Get(key).Children, options)
+			key, ok := mapKey(v)
+			if !ok {
+				continue
+			}
+			walkDocumentEntry(result, key, t.MapIndex(v), walkStateHolder, entryFilters, selected, options)
 		}
 
 		return result
+	case reflect.Interface, reflect.Ptr:
+		if t.IsNil() {
+			return nil
+		}
+		return getValue(t.Elem(), walkStateHolder, filters, options)
+	case reflect.Invalid:
+		return nil
 	case reflect.Struct:
-		val, ok := t.Interface().(json.Marshaler)
-		if ok {
-			bytes, err := val.(json.Marshaler).MarshalJSON()
-			if err != nil {
-				fmt.Println(err)
-			}
-
-			return string(bytes)
+		if parentKey != "" {
+			filters = filters.Get(parentKey).Children
 		}
-
 		return walkByExpansion(t, walkStateHolder, filters, recursive)
 	default:
 		return t.Interface()
@@ -369,6 +836,216 @@ func getValue(t reflect.Value, walkStateHolder WalkStateHolder, filters Filters,
 	return ""
 }
 
+var (
+	marshalerType     = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// marshaledValue returns the JSON value of a value which marshals itself, with the precedence of encoding/json:
+// json.Marshaler before encoding.TextMarshaler, each on the value or, if it is addressable, on its pointer.
+func marshaledValue(t reflect.Value) (interface{}, bool) {
+	if !t.IsValid() || t.Kind() == reflect.Interface || !t.CanInterface() {
+		return nil, false
+	}
+	candidates := []reflect.Value{t}
+	if t.Kind() != reflect.Ptr && t.CanAddr() {
+		candidates = append(candidates, t.Addr())
+	}
+
+	for _, candidate := range candidates {
+		if !candidate.Type().Implements(marshalerType) {
+			continue
+		}
+		if candidate.Kind() == reflect.Ptr && candidate.IsNil() {
+			return nil, true
+		}
+		bytes, err := candidate.Interface().(json.Marshaler).MarshalJSON()
+		var value interface{}
+		if err == nil {
+			err = json.Unmarshal(bytes, &value)
+		}
+		if err != nil {
+			fmt.Printf("Warning: could not marshal %v: %v \n", t.Type(), err)
+			return nil, true
+		}
+		return value, true
+	}
+
+	for _, candidate := range candidates {
+		if !candidate.Type().Implements(textMarshalerType) {
+			continue
+		}
+		if candidate.Kind() == reflect.Ptr && candidate.IsNil() {
+			return nil, true
+		}
+		text, err := candidate.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			fmt.Printf("Warning: could not marshal %v: %v \n", t.Type(), err)
+			return nil, true
+		}
+		return string(text), true
+	}
+	return nil, false
+}
+
+// indirect follows pointers and interfaces to the value they hold. Nil ones give an invalid value, written as null.
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// mapKey returns the key a map entry is written to, like encoding/json: by encoding.TextMarshaler, else strings as
+// they are and integers in decimal.
+func mapKey(k reflect.Value) (string, bool) {
+	if marshaler, ok := k.Interface().(encoding.TextMarshaler); ok {
+		if k.Kind() == reflect.Ptr && k.IsNil() {
+			return "", true
+		}
+		text, err := marshaler.MarshalText()
+		return string(text), err == nil
+	}
+	switch k.Kind() {
+	case reflect.String:
+		return k.String(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), true
+	}
+	return "", false
+}
+
+// documentFilters returns the filters for the entries of a map or ordered document, and whether all references among
+// its entries are selected. A document held by a field is filtered like a struct: expand=links expands the references
+// in it, expand=links(owner) the one under owner only. The root document is filtered by its keys.
+func documentFilters(filters Filters, options func() (bool, string)) (Filters, bool) {
+	_, parentKey := options()
+	if parentKey == "" {
+		return filters, false
+	}
+	entryFilters := filters.Get(parentKey).Children
+	return entryFilters, filters.Contains(parentKey) && entryFilters.IsEmpty()
+}
+
+// walkDocumentEntry writes the walked value of a map or ordered document entry to the result, or creates the expansion
+// task for it if it is a selected reference.
+func walkDocumentEntry(result map[string]interface{}, key string, value reflect.Value, walkStateHolder WalkStateHolder, filters Filters, selected bool, options func() (bool, string)) {
+	recursive, _ := options()
+	if key == HALLinksKey {
+		// the links are embedded by relation, they are kept as they are
+		addHALExpansionTasks(value, result, walkStateHolder, filters, recursive)
+		result[key] = getValue(value, walkStateHolder, Filters{}, func() (bool, string) {
+			return false, key
+		})
+		return
+	}
+	value = indirect(value)
+	if selected || filters.Contains(key) || recursive {
+		if reference, resolver, ok := walkStateHolder.resolvers.testForReferences(value); ok {
+			addMapEntryExpansionTask(result, key, value.Interface(), reference, resolver, walkStateHolder)
+			return
+		}
+	}
+	result[key] = getValue(value, walkStateHolder, filters, func() (bool, string) {
+		return recursive, key
+	})
+}
+
+// addMapEntryExpansionTask creates the expansion task for a reference held by a map under the given key.
+func addMapEntryExpansionTask(result map[string]interface{}, key string, original interface{}, reference Reference, resolver Resolver, walkStateHolder WalkStateHolder) {
+	result[key] = original
+
+	var resolveTask ExpansionTask
+	resolveTask.Reference = reference
+	resolveTask.Resolver = resolver.GetName()
+	resolveTask.Success = func(value interface{}) {
+		result[key] = expandedValue{original: original, value: value}
+	}
+	resolveTask.Error = func(err error) {
+		result[key] = expandedValue{original: original, err: err}
+	}
+	walkStateHolder.AddExpansionTask(resolveTask)
+}
+
+// addHALExpansionTasks creates expansion tasks for the links of a HAL resource, which embed the linked resources
+// under _embedded by their relation. With * every relation except self and curies is embedded.
+func addHALExpansionTasks(links reflect.Value, result map[string]interface{}, walkStateHolder WalkStateHolder, filters Filters, recursive bool) {
+	linksByRelation, ok := decodedObject(links)
+	if !ok {
+		return
+	}
+	embed := func(relation string, value interface{}) {
+		embedded, ok := result[HALEmbeddedKey].(map[string]interface{})
+		if !ok {
+			embedded = make(map[string]interface{})
+			result[HALEmbeddedKey] = embedded
+		}
+		embedded[relation] = value
+	}
+
+	for relation, link := range linksByRelation {
+		if !filters.Contains(relation) && (!recursive || relation == "self" || relation == "curies") {
+			continue
+		}
+		relation := relation
+
+		if linkList, ok := link.([]interface{}); ok {
+			resources := make([]interface{}, len(linkList))
+			for i, item := range linkList {
+				resources[i] = item
+				object, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				reference, resolver, ok := walkStateHolder.resolvers.testForReferences(reflect.ValueOf(halLink{object}))
+				if !ok {
+					continue
+				}
+				var resolveTask ExpansionTask
+				var localCounter = i
+				resolveTask.Reference = reference
+				resolveTask.Resolver = resolver.GetName()
+				resolveTask.Success = func(value interface{}) {
+					resources[localCounter] = value
+					embed(relation, resources)
+				}
+				resolveTask.Error = func(err error) {
+					resources[localCounter] = failedValue(item, err)
+					embed(relation, resources)
+				}
+				walkStateHolder.AddExpansionTask(resolveTask)
+			}
+			continue
+		}
+
+		object, ok := link.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		reference, resolver, ok := walkStateHolder.resolvers.testForReferences(reflect.ValueOf(halLink{object}))
+		if !ok {
+			continue
+		}
+		var resolveTask ExpansionTask
+		resolveTask.Reference = reference
+		resolveTask.Resolver = resolver.GetName()
+		resolveTask.Success = func(value interface{}) {
+			embed(relation, value)
+		}
+		resolveTask.Error = func(err error) {
+			if errorPolicy != KeepReference {
+				embed(relation, failedValue(reference.OriginalReference, err))
+			}
+		}
+		walkStateHolder.AddExpansionTask(resolveTask)
+	}
+}
+
 func expandChildren(m map[string]interface{}, filters Filters, recursive bool) map[string]interface{} {
 	result := make(map[string]interface{})
 	if true {
@@ -475,7 +1152,7 @@ func isEmptyValue(v reflect.Value) bool {
 	case reflect.Array, reflect.Map, reflect.Slice:
 		return v.Len() == 0
 	case reflect.String:
-		return v.Len() == 0 || v.Interface() == emptyTimeValue
+		return v.Len() == 0
 	case reflect.Bool:
 		return !v.Bool()
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
@@ -486,6 +1163,10 @@ func isEmptyValue(v reflect.Value) bool {
 		return v.Float() == 0
 	case reflect.Interface, reflect.Ptr:
 		return v.IsNil()
+	case reflect.Struct:
+		if t, ok := v.Interface().(time.Time); ok {
+			return t.IsZero()
+		}
 	}
 	return false
 }
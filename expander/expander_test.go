@@ -1,10 +1,15 @@
 package expander
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"strconv"
 	"testing"
 	"time"
@@ -341,6 +346,62 @@ func TestExpanderFiltering(t *testing.T) {
 			}
 		})
 	})
+
+	Convey("It should filter values of any shape:", t, func() {
+		info := Info{"A name", 100}
+		cases := []struct {
+			name     string
+			value    interface{}
+			expected interface{}
+		}{
+			{"slices with nil as first element", []interface{}{nil, map[string]interface{}{"Name": "A name", "Age": 100}},
+				[]interface{}{nil, map[string]interface{}{"Name": "A name"}}},
+			{"slices of typed maps", []map[string]string{{"Name": "A name", "Age": "100"}},
+				[]interface{}{map[string]interface{}{"Name": "A name"}}},
+			{"typed maps", map[string]int{"Name": 1, "Age": 100}, map[string]interface{}{"Name": 1}},
+			{"mixed slices", []interface{}{"a string", OrderedMap{[]string{"Age", "Name"}, map[string]interface{}{"Age": 100, "Name": "A name"}}, []interface{}{&info}},
+				[]interface{}{"a string", OrderedMap{[]string{"Name"}, map[string]interface{}{"Name": "A name"}}, []interface{}{map[string]interface{}{"Name": "A name"}}}},
+			{"slices of maps with nil", []map[string]interface{}{nil, {"Name": "A name", "Age": 100}},
+				[]map[string]interface{}{nil, {"Name": "A name"}}},
+			{"structs", info, map[string]interface{}{"Name": "A name"}},
+			{"arrays of ordered maps", [1]OrderedMap{{[]string{"Name", "Age"}, map[string]interface{}{"Name": "A name", "Age": 100}}},
+				[]interface{}{OrderedMap{[]string{"Name"}, map[string]interface{}{"Name": "A name"}}}},
+		}
+
+		for _, c := range cases {
+			Convey("Filtering should select the fields of "+c.name, func() {
+				filters := Filters{Filter{Value: "Value", Children: Filters{Filter{Value: "Name"}}}}
+
+				result := walkByFilter(map[string]interface{}{"Value": c.value, "Other": 1}, filters)
+
+				So(result, ShouldResemble, map[string]interface{}{"Value": c.expected})
+			})
+		}
+
+		Convey("Filtering should keep values of any shape without sub filters", func() {
+			typed := []map[string]string{{"Name": "A name"}}
+
+			result := walkByFilter(map[string]interface{}{"Value": typed, "Info": info}, Filters{})
+
+			So(result["Value"], ShouldResemble, typed)
+			So(result["Info"], ShouldResemble, info)
+		})
+
+		Convey("Filtering should apply to resolved values of any shape", func() {
+			ClearResolvers()
+			AddResolver(NewInMemoryResolver("countries", reflect.TypeOf(CountryCode("")), map[string]interface{}{
+				"CH": Info{"Switzerland", 8},
+			}))
+			address := Address{Street: "Bahnhofstrasse", Country: "CH", Neighbors: []CountryCode{"CH", "XX"}}
+
+			result := Expand(address, "*", "Country(Name),Neighbors(Name)")
+
+			So(result, ShouldResemble, map[string]interface{}{
+				"Country":   map[string]interface{}{"Name": "Switzerland"},
+				"Neighbors": []interface{}{map[string]interface{}{"Name": "Switzerland"}, CountryCode("XX")},
+			})
+		})
+	})
 }
 
 func TestExpanderFiltering2(t *testing.T) {
@@ -441,9 +502,9 @@ func TestRecognizeDBRefExpansion(t *testing.T) {
 			AddResolver(NewMongoDbRefResolver(uris, false))
 
 			mockedFn := makeGetCall
-			makeGetCall = func(url *url.URL) ([]byte, bool) {
+			makeGetCall = func(ctx context.Context, url *url.URL) ([]byte, error) {
 				result, _ := json.Marshal(info)
-				return result, true
+				return result, nil
 			}
 
 			result := Expand(simple, "*", "")
@@ -471,9 +532,9 @@ func TestRecognizeDBRefExpansion(t *testing.T) {
 			}
 			AddResolver(NewMongoDbRefResolver(uris, false))
 			mockedFn := makeGetCall
-			makeGetCall = func(url *url.URL) ([]byte, bool) {
+			makeGetCall = func(ctx context.Context, url *url.URL) ([]byte, error) {
 				result, _ := json.Marshal(info)
-				return result, true
+				return result, nil
 			}
 
 			result := Expand(simple, "*", "")
@@ -514,23 +575,23 @@ func TestResolveMongoDBRefs(t *testing.T) {
 		mockedFn := makeGetCall
 
 		apiCallCounter := 0
-		makeGetCall = func(murl *url.URL) ([]byte, bool) {
+		makeGetCall = func(ctx context.Context, murl *url.URL) ([]byte, error) {
 			if murl == nil {
-				return []byte{}, false
+				return []byte{}, errors.New("no URI given")
 			}
 			apiCallCounter++
 			var bulkResponse struct {
 				Data []interface{} `json:"data"`
 			}
-			if murl.String() == "http://some-uri?ids=1,2," {
+			if murl.String() == "http://some-uri?ids=1,2" {
 				bulkResponse.Data = append(bulkResponse.Data, info)
 				bulkResponse.Data = append(bulkResponse.Data, info2)
-			} else if murl.String() == "http://some-other-uri?ids=3," {
+			} else if murl.String() == "http://some-other-uri?ids=3" {
 				bulkResponse.Data = append(bulkResponse.Data, info3)
 			}
 
 			result, _ := json.Marshal(bulkResponse)
-			return result, true
+			return result, nil
 		}
 		result := Expand(simple, "*", "")
 
@@ -551,6 +612,455 @@ func TestResolveMongoDBRefs(t *testing.T) {
 	})
 }
 
+func TestMatchBulkResponses(t *testing.T) {
+	Convey("It should match the documents of a bulk response by their id:", t, func() {
+		refs := []Reference{
+			{Id: "1", OriginalReference: MongoDBRef{Id: "1", Collection: "a collection"}},
+			{Id: "2", OriginalReference: MongoDBRef{Id: "2", Collection: "a collection"}},
+			{Id: "3", OriginalReference: MongoDBRef{Id: "3", Collection: "a collection"}},
+		}
+		uris := map[string]string{"a collection": "http://some-uri?ids="}
+		mockedFn := makeGetCall
+
+		Convey("Documents should be matched regardless of their order, extras should be ignored and missing ids reported", func() {
+			makeGetCall = func(ctx context.Context, murl *url.URL) ([]byte, error) {
+				return []byte(`{"data": [{"_id": "3", "Name": "C"}, {"_id": "9", "Name": "X"}, {"_id": "1", "Name": "A"}]}`), nil
+			}
+			resolver := NewMongoDbRefResolver(uris, true)
+
+			result := resolver.ResolveRef(refs)
+
+			So(result["1"].(map[string]interface{})["Name"], ShouldEqual, "A")
+			So(result["3"].(map[string]interface{})["Name"], ShouldEqual, "C")
+			So(result["2"], ShouldImplement, (*error)(nil))
+			So(result, ShouldNotContainKey, "9")
+		})
+
+		Convey("ObjectIds and numeric ids should be matched as strings", func() {
+			makeGetCall = func(ctx context.Context, murl *url.URL) ([]byte, error) {
+				return []byte(`{"data": [{"_id": {"$oid": "1"}, "Name": "A"}, {"_id": 2, "Name": "B"}]}`), nil
+			}
+			resolver := NewMongoDbRefResolver(uris, true)
+
+			result := resolver.ResolveRef(refs)
+
+			So(result["1"].(map[string]interface{})["Name"], ShouldEqual, "A")
+			So(result["2"].(map[string]interface{})["Name"], ShouldEqual, "B")
+		})
+
+		Convey("Large numeric ids should be matched without losing precision", func() {
+			makeGetCall = func(ctx context.Context, murl *url.URL) ([]byte, error) {
+				return []byte(`{"data": [{"_id": 12345678901234567, "Name": "L"}, {"_id": 12345678901234568, "Name": "M"}]}`), nil
+			}
+			large := []Reference{{Id: "12345678901234567", OriginalReference: MongoDBRef{Id: "12345678901234567", Collection: "a collection"}}}
+
+			result := NewMongoDbRefResolver(uris, true).ResolveRef(large)
+			So(result["12345678901234567"].(map[string]interface{})["Name"], ShouldEqual, "L")
+
+			result = NewMongoDbRefResolver(uris, true).WithOrderedDocuments().ResolveRef(large)
+			So(result["12345678901234567"].(OrderedMap).Values["Name"], ShouldEqual, "L")
+		})
+
+		Convey("The id field and the envelope key should be configurable", func() {
+			makeGetCall = func(ctx context.Context, murl *url.URL) ([]byte, error) {
+				return []byte(`{"items": [{"key": "1", "Name": "A"}]}`), nil
+			}
+			resolver := NewMongoDbRefResolver(uris, true).WithBulkIdField("key").WithBulkEnvelope("items")
+
+			result := resolver.ResolveRef(refs)
+
+			So(result["1"].(map[string]interface{})["Name"], ShouldEqual, "A")
+		})
+
+		Convey("A bulk response without envelope should be accepted", func() {
+			makeGetCall = func(ctx context.Context, murl *url.URL) ([]byte, error) {
+				return []byte(`[{"_id": "2", "Name": "B"}]`), nil
+			}
+			resolver := NewMongoDbRefResolver(uris, true).WithBulkEnvelope("")
+
+			result := resolver.ResolveRef(refs)
+
+			So(result["2"].(map[string]interface{})["Name"], ShouldEqual, "B")
+		})
+
+		Convey("A malformed bulk response should be reported for every id", func() {
+			makeGetCall = func(ctx context.Context, murl *url.URL) ([]byte, error) {
+				return []byte(`{"data": `), nil
+			}
+			resolver := NewMongoDbRefResolver(uris, true)
+
+			result := resolver.ResolveRef(refs)
+
+			So(len(result), ShouldEqual, 3)
+			for _, value := range result {
+				So(value, ShouldImplement, (*error)(nil))
+			}
+		})
+
+		Reset(func() {
+			makeGetCall = mockedFn
+		})
+	})
+}
+
+func TestBulkRequests(t *testing.T) {
+	Convey("It should build bulk requests which fit the service:", t, func() {
+		refs := []Reference{
+			{Id: "1", OriginalReference: MongoDBRef{Id: "1", Collection: "a collection"}},
+			{Id: "2,a", OriginalReference: MongoDBRef{Id: "2,a", Collection: "a collection"}},
+			{Id: "3", OriginalReference: MongoDBRef{Id: "3", Collection: "a collection"}},
+		}
+		uris := map[string]string{"a collection": "http://some-uri?ids="}
+		mockedGet := makeGetCall
+		mockedPost := makePostCall
+
+		Convey("Ids should be escaped, separated by commas and split into chunks", func() {
+			var calledURLs []string
+			makeGetCall = func(ctx context.Context, murl *url.URL) ([]byte, error) {
+				calledURLs = append(calledURLs, murl.String())
+				return []byte(`{"data": [{"_id": "1"}, {"_id": "2,a"}, {"_id": "3"}]}`), nil
+			}
+			resolver := NewMongoDbRefResolver(uris, true).WithMaxIdsPerRequest(2)
+
+			result := resolver.ResolveRef(refs)
+
+			So(calledURLs, ShouldResemble, []string{"http://some-uri?ids=1,2%2Ca", "http://some-uri?ids=3"})
+			So(len(result), ShouldEqual, 3)
+			for _, value := range result {
+				So(value, ShouldHaveSameTypeAs, map[string]interface{}{})
+			}
+		})
+
+		Convey("Ids should be sent as JSON body when POST is configured", func() {
+			var calledURL string
+			var requestBody map[string][]string
+			makePostCall = func(ctx context.Context, murl *url.URL, body []byte) ([]byte, error) {
+				calledURL = murl.String()
+				json.Unmarshal(body, &requestBody)
+				return []byte(`{"data": [{"_id": "1"}, {"_id": "2,a"}, {"_id": "3"}]}`), nil
+			}
+			resolver := NewMongoDbRefResolver(map[string]string{"a collection": "http://some-uri/bulk"}, true).WithBulkPost("ids")
+
+			result := resolver.ResolveRef(refs)
+
+			So(calledURL, ShouldEqual, "http://some-uri/bulk")
+			So(requestBody["ids"], ShouldResemble, []string{"1", "2,a", "3"})
+			So(result["2,a"], ShouldHaveSameTypeAs, map[string]interface{}{})
+		})
+
+		Convey("POST requests should reach the service with a JSON body", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var ids []string
+				json.NewDecoder(r.Body).Decode(&ids)
+				w.Header().Set("Content-Type", "application/json")
+				if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" || len(ids) != 3 {
+					w.WriteHeader(http.StatusBadRequest)
+				}
+				w.Write([]byte(`[{"_id": "1"}]`))
+			}))
+			defer server.Close()
+			resolver := NewMongoDbRefResolver(map[string]string{"a collection": server.URL}, true).WithBulkPost("").WithBulkEnvelope("")
+
+			result := resolver.ResolveRef(refs)
+
+			So(result["1"], ShouldHaveSameTypeAs, map[string]interface{}{})
+		})
+
+		Reset(func() {
+			makeGetCall = mockedGet
+			makePostCall = mockedPost
+		})
+	})
+}
+
+func TestResolveErrors(t *testing.T) {
+	Convey("It should not embed failed responses as expanded values:", t, func() {
+		ClearResolvers()
+		simple := SimpleWithDBRef{Name: "foo", Ref: DBRef{"a collection", MongoId("123"), "a database"}}
+
+		Convey("Non-2xx responses should be reported as errors", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"message": "not found"}`))
+			}))
+			defer server.Close()
+			uri, _ := url.Parse(server.URL + "/123")
+
+			_, err := makeGetCall(context.Background(), uri)
+
+			So(err, ShouldResemble, HttpStatusError{Method: "GET", URL: uri.String(), StatusCode: http.StatusNotFound})
+		})
+
+		Convey("Responses which are not JSON should be reported as errors", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				w.Write([]byte("<html></html>"))
+			}))
+			defer server.Close()
+			uri, _ := url.Parse(server.URL + "/123")
+
+			_, err := makeGetCall(context.Background(), uri)
+
+			So(err, ShouldResemble, ContentTypeError{Method: "GET", URL: uri.String(), ContentType: "text/html"})
+		})
+
+		Convey("A null body should not be used as expanded value", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				w.Write([]byte("null"))
+			}))
+			defer server.Close()
+			AddResolver(NewMongoDbRefResolver(map[string]string{"a collection": server.URL + "/"}, false))
+
+			result := Expand(simple, "*", "")
+
+			So(result["Ref"], ShouldResemble, simple.Ref)
+		})
+
+		Convey("The error policy should decide what replaces a failed reference", func() {
+			mockedFn := makeGetCall
+			makeGetCall = func(ctx context.Context, murl *url.URL) ([]byte, error) {
+				return nil, HttpStatusError{Method: "GET", URL: murl.String(), StatusCode: http.StatusInternalServerError}
+			}
+			AddResolver(NewMongoDbRefResolver(map[string]string{"a collection": "http://some-uri/id/"}, false))
+
+			SetErrorPolicy(NullOnError)
+			result := Expand(simple, "*", "")
+			So(result["Ref"], ShouldBeNil)
+
+			SetErrorPolicy(ErrorObject)
+			result = Expand(simple, "*", "")
+			errorObject := result["Ref"].(map[string]interface{})
+			So(errorObject["error"], ShouldEqual, "GET http://some-uri/id/123 returned status 500")
+			So(errorObject["reference"], ShouldResemble, simple.Ref)
+
+			multiple := SimpleWithMultipleDBRefs{Name: "foo", Refs: []DBRef{simple.Ref}}
+			resultArray := Expand(multiple, "*", "")["Refs"].([]interface{})
+			So(resultArray[0].(map[string]interface{})["error"], ShouldEqual, "GET http://some-uri/id/123 returned status 500")
+
+			SetErrorPolicy(KeepReference)
+			makeGetCall = mockedFn
+		})
+
+		Reset(func() {
+			ClearResolvers()
+		})
+	})
+}
+
+func TestExpansionTimeouts(t *testing.T) {
+	Convey("It should return partially expanded data when resolvers are too slow:", t, func() {
+		ClearResolvers()
+		release := make(chan bool)
+		cancelled := make(chan bool, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/slow/123" {
+				select {
+				case <-release:
+				case <-r.Context().Done():
+					cancelled <- true
+					return
+				}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"Name": "A name"}`))
+		}))
+		uris := map[string]string{"slow": server.URL + "/slow/", "fast": server.URL + "/fast/"}
+		AddResolver(NewMongoDbRefResolver(uris, false))
+		slow := DBRef{"slow", MongoId("123"), "a database"}
+		fast := DBRef{"fast", MongoId("456"), "a database"}
+
+		Convey("A resolver exceeding its timeout should leave its references unresolved and report them", func() {
+			SetResolverTimeout("MongoDbRefResolver", 50*time.Millisecond)
+			simple := SimpleWithDBRef{Name: "foo", Ref: slow}
+
+			result, report := ExpandWithReport(simple, "*", "")
+
+			So(result["Ref"], ShouldResemble, slow)
+			So(len(report.TimedOut), ShouldEqual, 1)
+			So(report.TimedOut[0].Id, ShouldEqual, "123")
+		})
+
+		Convey("The expansion timeout should apply to all items of an array together", func() {
+			SetExpansionTimeout(50 * time.Millisecond)
+			items := []SimpleWithDBRef{{Name: "fast", Ref: fast}, {Name: "slow", Ref: slow}, {Name: "late", Ref: fast}}
+
+			result, report := ExpandArrayWithReport(items, "*", "")
+
+			So(result[0].(map[string]interface{})["Ref"].(map[string]interface{})["Name"], ShouldEqual, "A name")
+			So(result[1].(map[string]interface{})["Ref"], ShouldResemble, slow)
+			So(result[2].(map[string]interface{})["Ref"], ShouldResemble, fast)
+			So(len(report.TimedOut), ShouldEqual, 2)
+		})
+
+		Convey("Requests of a resolver exceeding its timeout should be cancelled", func() {
+			SetResolverTimeout("MongoDbRefResolver", 50*time.Millisecond)
+
+			ExpandWithReport(SimpleWithDBRef{Name: "foo", Ref: slow}, "*", "")
+
+			select {
+			case <-cancelled:
+			case <-time.After(time.Second):
+				So("the request was not cancelled", ShouldBeEmpty)
+			}
+		})
+
+		Convey("Timeouts should be changeable while expansions are running", func() {
+			done := make(chan bool)
+			go func() {
+				for i := 0; i < 100; i++ {
+					SetResolverTimeout("MongoDbRefResolver", time.Duration(i+1)*time.Second)
+				}
+				close(done)
+			}()
+			for i := 0; i < 10; i++ {
+				Expand(SimpleWithDBRef{Name: "foo", Ref: fast}, "*", "")
+			}
+			<-done
+
+			So(Expand(SimpleWithDBRef{Name: "foo", Ref: fast}, "*", "")["Ref"], ShouldNotResemble, fast)
+		})
+
+		Convey("Resolvers finishing in time should not be reported", func() {
+			SetExpansionTimeout(time.Second)
+			simple := SimpleWithDBRef{Name: "foo", Ref: fast}
+
+			result, report := ExpandWithReport(simple, "*", "")
+
+			So(result["Ref"].(map[string]interface{})["Name"], ShouldEqual, "A name")
+			So(report.TimedOut, ShouldBeEmpty)
+		})
+
+		Reset(func() {
+			SetResolverTimeout("MongoDbRefResolver", 0)
+			SetExpansionTimeout(0)
+			ClearResolvers()
+			close(release)
+			server.Close()
+		})
+	})
+}
+
+func TestResolverPanics(t *testing.T) {
+	Convey("A panicking resolver should only fail its own references:", t, func() {
+		ClearResolvers()
+		switzerland := map[string]interface{}{"name": "Switzerland"}
+		AddResolver(NewInMemoryResolver("countries", reflect.TypeOf(CountryCode("")), map[string]interface{}{"CH": switzerland}))
+		isUser := func(v reflect.Value) (Reference, bool) {
+			if v.Type() != reflect.TypeOf(UserId("")) {
+				return Reference{}, false
+			}
+			return Reference{Id: v.String(), OriginalReference: v.Interface()}, true
+		}
+		AddResolver(NewResolverFunc("users", isUser, func(refs []Reference) map[string]interface{} {
+			panic("lookup failed")
+		}))
+		SetErrorPolicy(ErrorObject)
+
+		result := Expand(map[string]interface{}{"country": CountryCode("CH"), "user": UserId("1")}, "*", "")
+
+		So(result["country"], ShouldResemble, switzerland)
+		So(result["user"], ShouldResemble, map[string]interface{}{
+			"error":     ResolverPanicError{Name: "users", Value: "lookup failed"}.Error(),
+			"reference": UserId("1"),
+		})
+
+		Reset(func() {
+			ClearResolvers()
+			SetErrorPolicy(KeepReference)
+		})
+	})
+}
+
+func TestTaggedReferences(t *testing.T) {
+	Convey("It should expand fields tagged with the resolver to use:", t, func() {
+		ClearResolvers()
+		users := map[string]interface{}{
+			"1": map[string]interface{}{"name": "Ann"},
+			"2": map[string]interface{}{"name": "Bob"},
+		}
+		tags := map[string]interface{}{"go": map[string]interface{}{"label": "Go"}}
+		AddResolver(NewInMemoryResolver("users", reflect.TypeOf(UserId("")), users))
+		AddResolver(NewInMemoryResolver("tags", reflect.TypeOf(TagName("")), tags))
+		post := TaggedPost{Title: "A title", AuthorId: "1", EditorIds: []UserId{"2", "3"}, Tags: []TagName{"go"}}
+
+		Convey("Tagged ids should be expanded into the key given by the tag and keep the id", func() {
+			result := Expand(post, "*", "")
+
+			So(result["authorId"], ShouldEqual, "1")
+			So(result["author"], ShouldResemble, users["1"])
+			So(result["editorIds"], ShouldResemble, []interface{}{"2", "3"})
+			So(result["editors"], ShouldResemble, []interface{}{users["2"]})
+			So(result["Tags"], ShouldResemble, []interface{}{tags["go"]})
+		})
+
+		Convey("Tagged ids which cannot be resolved should only be kept under the key of the id", func() {
+			failed := TaggedPost{Title: "A title", AuthorId: "9", EditorIds: []UserId{"8", "9"}}
+			result := Expand(failed, "*", "")
+
+			So(result["authorId"], ShouldEqual, "9")
+			So(result, ShouldNotContainKey, "author")
+			So(result["editorIds"], ShouldResemble, []interface{}{"8", "9"})
+			So(result, ShouldNotContainKey, "editors")
+
+			SetErrorPolicy(NullOnError)
+			result = Expand(failed, "*", "")
+
+			So(result, ShouldContainKey, "author")
+			So(result["author"], ShouldBeNil)
+			So(result["editors"], ShouldResemble, []interface{}{nil, nil})
+		})
+
+		Convey("Empty tagged ids should not be expanded", func() {
+			result := Expand(post, "*", "")
+
+			So(result, ShouldNotContainKey, "reviewerId")
+			So(result, ShouldNotContainKey, "reviewer")
+		})
+
+		Convey("Tagged ids should only be expanded when their key is in the expansion filter", func() {
+			result := Expand(post, "author", "")
+
+			So(result["author"], ShouldResemble, users["1"])
+			So(result, ShouldNotContainKey, "editors")
+			So(result["Tags"], ShouldResemble, []interface{}{"go"})
+		})
+
+		Convey("Tagged ids should be detected by the resolver of the tag", func() {
+			mockedFn := makeGetCall
+			makeGetCall = func(ctx context.Context, murl *url.URL) ([]byte, error) {
+				return []byte(`{"name": "Ann"}`), nil
+			}
+			defer func() { makeGetCall = mockedFn }()
+			AddResolver(NewMongoDbRefResolver(map[string]string{"users": "http://some-uri/id/"}, false))
+			SetErrorPolicy(ErrorObject)
+			ref := DBRef{"users", MongoId("1"), "a database"}
+
+			result := Expand(TaggedDBRefPost{Title: "A title", AuthorId: ref, Slug: "a-title"}, "*", "")
+
+			So(result["authorId"], ShouldResemble, map[string]interface{}{"Collection": "users", "Id": "1", "Database": "a database"})
+			So(result["author"], ShouldResemble, map[string]interface{}{"name": "Ann"})
+			So(result["slug"], ShouldEqual, "a-title")
+			So(result["page"], ShouldResemble, map[string]interface{}{"error": ErrNotDetected.Error(), "reference": "a-title"})
+		})
+
+		Convey("Tags naming no registered resolver should take the error path", func() {
+			ClearResolvers()
+			SetErrorPolicy(ErrorObject)
+
+			result := Expand(post, "author", "")
+
+			So(result["author"], ShouldResemble, map[string]interface{}{"error": UnknownResolverError{"users"}.Error(), "reference": UserId("1")})
+		})
+
+		Reset(func() {
+			ClearResolvers()
+			SetErrorPolicy(KeepReference)
+		})
+	})
+}
+
 func TestInvalidFilters(t *testing.T) {
 	Convey("It should detect invalid filters and return data untouched", t, func() {
 		Convey("Open brackets should be handled as invalid filter and not expand", func() {
@@ -558,9 +1068,9 @@ func TestInvalidFilters(t *testing.T) {
 			info := Info{"A name", 100}
 
 			mockedFn := makeGetCall
-			makeGetCall = func(murl *url.URL) ([]byte, bool) {
+			makeGetCall = func(ctx context.Context, murl *url.URL) ([]byte, error) {
 				result, _ := json.Marshal(info)
-				return result, true
+				return result, nil
 			}
 
 			result := Expand(singleLevel, "*,((", "")
@@ -604,9 +1114,9 @@ func TestFetchFromURI(t *testing.T) {
 			info := Info{"A name", 100}
 
 			mockedFn := makeGetCall
-			makeGetCall = func(murl *url.URL) ([]byte, bool) {
+			makeGetCall = func(ctx context.Context, murl *url.URL) ([]byte, error) {
 				result, _ := json.Marshal(info)
-				return result, true
+				return result, nil
 			}
 
 			result := Expand(singleLevel, "*", "")
@@ -631,10 +1141,10 @@ func TestFetchFromURI(t *testing.T) {
 
 			mockedFn := makeGetCall
 			index := 0
-			makeGetCall = func(murl *url.URL) ([]byte, bool) {
+			makeGetCall = func(ctx context.Context, murl *url.URL) ([]byte, error) {
 				result, _ := json.Marshal(info[index])
 				index = index + 1
-				return result, true
+				return result, nil
 			}
 
 			simpleWithLinks := SimpleWithLinks{"something", links}
@@ -662,15 +1172,15 @@ func TestFetchFromURI(t *testing.T) {
 
 					mockedFn := makeGetCall
 					index := 0
-					makeGetCall = func(murl *url.URL) ([]byte, bool) {
+					makeGetCall = func(ctx context.Context, murl *url.URL) ([]byte, error) {
 						var result []byte
 						if index > 0 {
 							result, _ = json.Marshal(info)
-							return result, true
+							return result, nil
 						}
 						result, _ = json.Marshal(singleLevel2)
 						index = index + 1
-						return result, true
+						return result, nil
 					}
 
 					result := Expand(singleLevel1, "*", "")
@@ -691,10 +1201,10 @@ func TestFetchFromURI(t *testing.T) {
 				singleLevel2 := SimpleSingleLevel{S: "two", L: Link{Ref: "http://valid2/info", Rel: "nothing2", Verb: "GET"}}
 
 				mockedFn := makeGetCall
-				makeGetCall = func(murl *url.URL) ([]byte, bool) {
+				makeGetCall = func(ctx context.Context, murl *url.URL) ([]byte, error) {
 					var result []byte
 					result, _ = json.Marshal(singleLevel2)
-					return result, true
+					return result, nil
 				}
 
 				result := Expand(singleLevel1, "L", "")
@@ -722,15 +1232,15 @@ func TestFetchFromURI(t *testing.T) {
 
 				mockedFn := makeGetCall
 				index := 0
-				makeGetCall = func(murl *url.URL) ([]byte, bool) {
+				makeGetCall = func(ctx context.Context, murl *url.URL) ([]byte, error) {
 					var result []byte
 					index = index + 1
 					if index%2 == 0 {
 						result, _ = json.Marshal(info)
-						return result, true
+						return result, nil
 					}
 					result, _ = json.Marshal(singleLevel)
-					return result, true
+					return result, nil
 				}
 
 				result := Expand(simpleWithLinks, "Members(L)", "Name,Members(S,L)")
@@ -799,13 +1309,13 @@ func TestFetchFromURI(t *testing.T) {
 
 			AddResolver(NewMongoDbRefResolver(uris, false))
 			mockedFn := makeGetCall
-			makeGetCall = func(murl *url.URL) ([]byte, bool) {
+			makeGetCall = func(ctx context.Context, murl *url.URL) ([]byte, error) {
 				if murl.Path == "/id/123" {
 					result, _ := json.Marshal(info1)
-					return result, true
+					return result, nil
 				} else {
 					result, _ := json.Marshal(info2)
-					return result, true
+					return result, nil
 				}
 			}
 
@@ -884,3 +1394,19 @@ type ComplexSingleLevel struct {
 	SSL SimpleSingleLevel
 	S   string
 }
+
+type TagName string
+
+type TaggedPost struct {
+	Title      string
+	AuthorId   UserId    `json:"authorId" expand:"author,resolver=users"`
+	ReviewerId UserId    `json:"reviewerId,omitempty" expand:"reviewer,resolver=users"`
+	EditorIds  []UserId  `json:"editorIds" expand:"editors,resolver=users"`
+	Tags       []TagName `expand:",resolver=tags"`
+}
+
+type TaggedDBRefPost struct {
+	Title    string
+	AuthorId DBRef  `json:"authorId" expand:"author,resolver=MongoDbRefResolver"`
+	Slug     string `json:"slug" expand:"page,resolver=MongoDbRefResolver"`
+}
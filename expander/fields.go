@@ -0,0 +1,246 @@
+package expander
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// structField is a field of a struct as encoding/json sees it: visible, named by its tag and possibly promoted from
+// an embedded struct.
+type structField struct {
+	name      string
+	tagged    bool
+	index     []int
+	typ       reflect.Type
+	field     reflect.StructField
+	omitempty bool
+	quoted    bool
+	expand    expandTag
+	hasExpand bool
+}
+
+type fieldCacheKey struct {
+	typ    reflect.Type
+	tags   string
+	naming int
+}
+
+var fieldCache sync.Map
+
+// cachedTypeFields is like typeFields with the configured tag priority and key naming, but only looks at each type
+// once.
+func cachedTypeFields(t reflect.Type) []structField {
+	tags := tagPriority
+	key := fieldCacheKey{t, tagPriorityKey(tags), keyNamingVersion}
+	if fields, ok := fieldCache.Load(key); ok {
+		return fields.([]structField)
+	}
+	fields, _ := fieldCache.LoadOrStore(key, typeFields(t, tags, keyNaming))
+	return fields.([]structField)
+}
+
+// typeFields returns the fields encoding/json encodes for the struct type, in the same order and with the same names,
+// reading the first of the given tags a field has and naming untagged fields by the key naming. Unexported and "-"
+// fields are left out, fields of embedded structs are promoted unless a field with the same name dominates them.
+func typeFields(t reflect.Type, tags []string, naming KeyNaming) []structField {
+	var current []structField
+	next := []structField{{typ: t}}
+
+	var count, nextCount map[reflect.Type]int
+	visited := make(map[reflect.Type]bool)
+
+	var fields []structField
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count, nextCount = nextCount, make(map[reflect.Type]int)
+
+		for _, f := range current {
+			if visited[f.typ] {
+				continue
+			}
+			visited[f.typ] = true
+
+			for i := 0; i < f.typ.NumField(); i++ {
+				sf := f.typ.Field(i)
+				if sf.Anonymous {
+					t := sf.Type
+					if t.Kind() == reflect.Ptr {
+						t = t.Elem()
+					}
+					if sf.PkgPath != "" && t.Kind() != reflect.Struct {
+						continue
+					}
+				} else if sf.PkgPath != "" {
+					continue
+				}
+
+				tag, tagName := fieldTag(sf, tags)
+				if tag == "-" {
+					continue
+				}
+				name, options := parseJsonTag(tag)
+				if !isValidKey(name) {
+					name = ""
+				}
+				index := make([]int, len(f.index)+1)
+				copy(index, f.index)
+				index[len(f.index)] = i
+
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+
+				quoted := false
+				if tagName == "json" && options.contains("string") {
+					switch ft.Kind() {
+					case reflect.Bool,
+						reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+						reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+						reflect.Float32, reflect.Float64,
+						reflect.String:
+						quoted = true
+					}
+				}
+
+				// bson inlines the fields of a struct like an untagged embedded struct
+				inline := (sf.Anonymous && name == "") || (tagName == "bson" && options.contains("inline"))
+				if !inline || ft.Kind() != reflect.Struct {
+					tagged := name != ""
+					if name == "" {
+						name = naming.name(sf.Name)
+					}
+					field := structField{
+						name:      name,
+						tagged:    tagged,
+						index:     index,
+						typ:       ft,
+						field:     sf,
+						omitempty: options.contains("omitempty"),
+						quoted:    quoted,
+					}
+					field.expand, field.hasExpand = parseExpandTag(sf.Tag.Get("expand"))
+					fields = append(fields, field)
+					if count[f.typ] > 1 {
+						// the same type embedded twice at this depth hides its fields, the duplicate makes that visible
+						fields = append(fields, field)
+					}
+					continue
+				}
+
+				nextCount[ft]++
+				if nextCount[ft] == 1 {
+					next = append(next, structField{name: ft.Name(), index: index, typ: ft})
+				}
+			}
+		}
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		x := fields
+		if x[i].name != x[j].name {
+			return x[i].name < x[j].name
+		}
+		if len(x[i].index) != len(x[j].index) {
+			return len(x[i].index) < len(x[j].index)
+		}
+		if x[i].tagged != x[j].tagged {
+			return x[i].tagged
+		}
+		return indexLess(x[i].index, x[j].index)
+	})
+
+	out := fields[:0]
+	for advance, i := 0, 0; i < len(fields); i += advance {
+		name := fields[i].name
+		for advance = 1; i+advance < len(fields); advance++ {
+			if fields[i+advance].name != name {
+				break
+			}
+		}
+		if advance == 1 {
+			out = append(out, fields[i])
+			continue
+		}
+		if dominant, ok := dominantField(fields[i : i+advance]); ok {
+			out = append(out, dominant)
+		}
+	}
+	fields = out
+
+	sort.Slice(fields, func(i, j int) bool {
+		return indexLess(fields[i].index, fields[j].index)
+	})
+	return fields
+}
+
+// dominantField returns the field which hides the others of the same name: the shallowest one, if it is the only one
+// at its depth or the only tagged one.
+func dominantField(fields []structField) (structField, bool) {
+	if len(fields) > 1 && len(fields[0].index) == len(fields[1].index) && fields[0].tagged == fields[1].tagged {
+		return structField{}, false
+	}
+	return fields[0], true
+}
+
+func indexLess(a, b []int) bool {
+	for k, x := range a {
+		if k >= len(b) {
+			return false
+		}
+		if x != b[k] {
+			return x < b[k]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// fieldByIndex returns the nested field, or false if it is promoted through a nil pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+type tagOptions string
+
+func parseJsonTag(tag string) (string, tagOptions) {
+	if index := strings.Index(tag, ","); index != -1 {
+		return tag[:index], tagOptions(tag[index+1:])
+	}
+	return tag, ""
+}
+
+func (this tagOptions) contains(option string) bool {
+	for _, current := range strings.Split(string(this), ",") {
+		if current == option {
+			return true
+		}
+	}
+	return false
+}
+
+// isValidKey reports whether a tag name is used as key by encoding/json.
+func isValidKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, c := range key {
+		switch {
+		case strings.ContainsRune("!#$%&()*+-./:;<=>?@[]^_{|}~ ", c):
+		case !unicode.IsLetter(c) && !unicode.IsDigit(c):
+			return false
+		}
+	}
+	return true
+}
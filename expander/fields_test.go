@@ -0,0 +1,107 @@
+package expander
+
+import (
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+type JsonBase struct {
+	Id       int `json:"id"`
+	Shadowed string
+	Conflict string
+}
+
+type JsonOther struct {
+	Conflict string
+	Extra    bool
+}
+
+type jsonHidden struct {
+	Promoted string
+	Shadowed string
+}
+
+type JsonCorpus struct {
+	JsonBase
+	*JsonOther
+	jsonHidden
+	Name     string  `json:"name"`
+	Ignored  string  `json:"-"`
+	Dash     string  `json:"-,"`
+	Count    int     `json:"count,string"`
+	Enabled  bool    `json:",string"`
+	Ratio    float64 `json:"ratio,string"`
+	Label    string  `json:"label,string"`
+	Small    float32
+	Quoted   string
+	Shadowed string
+	Empty    string   `json:",omitempty"`
+	Tagged   JsonBase `json:"tagged"`
+	Nested   []JsonBase
+	private  string
+}
+
+type JsonTaggedEmbedding struct {
+	JsonBase `json:"base"`
+	Id       string
+}
+
+type JsonDeepShadowing struct {
+	JsonCorpus
+	Id string `json:"id"`
+}
+
+// toJSONValues returns the value as encoding/json sees it, decoded into maps, slices and basic values.
+func toJSONValues(value interface{}) interface{} {
+	bytes, err := json.Marshal(value)
+	So(err, ShouldBeNil)
+	var result interface{}
+	So(json.Unmarshal(bytes, &result), ShouldBeNil)
+	return result
+}
+
+func TestEncodingJsonSemantics(t *testing.T) {
+	Convey("Walking without expansion should give the same JSON as encoding/json:", t, func() {
+		ClearResolvers()
+		base := JsonBase{Id: 7, Shadowed: "base", Conflict: "base"}
+		corpus := JsonCorpus{
+			JsonBase:   base,
+			JsonOther:  &JsonOther{Conflict: "other", Extra: true},
+			jsonHidden: jsonHidden{Promoted: "promoted", Shadowed: "hidden"},
+			Name:       "a name",
+			Ignored:    "ignored",
+			Dash:       "dash",
+			Count:      42,
+			Enabled:    true,
+			Ratio:      0.5,
+			Label:      "a label",
+			Small:      0.1,
+			Quoted:     `"quoted"`,
+			Shadowed:   "top",
+			Tagged:     base,
+			Nested:     []JsonBase{base},
+			private:    "private",
+		}
+		withoutOther := corpus
+		withoutOther.JsonOther = nil
+
+		cases := []struct {
+			name  string
+			value interface{}
+		}{
+			{"fields with tags, options and embedded structs", corpus},
+			{"fields promoted through a nil pointer", withoutOther},
+			{"a pointer to a struct", &corpus},
+			{"an embedded struct with a tag", JsonTaggedEmbedding{JsonBase: base, Id: "outer"}},
+			{"fields shadowed by shallower ones", JsonDeepShadowing{JsonCorpus: corpus, Id: "outer"}},
+			{"empty fields", JsonCorpus{Nested: []JsonBase{}}},
+		}
+
+		for _, c := range cases {
+			Convey("for "+c.name, func() {
+				So(toJSONValues(Expand(c.value, "", "")), ShouldResemble, toJSONValues(c.value))
+			})
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package expander
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// InvalidTargetError is returned when the target of ExpandInto or ExpandArrayInto is not a non-nil pointer of a
+// fitting kind.
+type InvalidTargetError struct {
+	Type reflect.Type
+}
+
+func (this InvalidTargetError) Error() string {
+	if this.Type == nil {
+		return "expansion target is nil"
+	}
+	return fmt.Sprintf("expansion target has to be a non-nil pointer, got %v", this.Type)
+}
+
+// ShapeMismatchError is returned when an expanded value does not fit the field of the target it is decoded into,
+// e.g. a reference field declared as string which was expanded to an object. Declare such fields as interface{} or
+// as the expanded type.
+type ShapeMismatchError struct {
+	Field    string
+	Value    string
+	Expected reflect.Type
+}
+
+func (this ShapeMismatchError) Error() string {
+	return fmt.Sprintf("expanded value of '%v' is %v and can not be decoded into %v", this.Field, this.Value, this.Expected)
+}
+
+// ExpandInto expands the data like Expand and decodes the result into the struct or map the target points to.
+// Fields are matched like encoding/json does.
+func ExpandInto(data interface{}, expansion, fields string, target interface{}) error {
+	if err := validateTarget(target, reflect.Struct, reflect.Map, reflect.Interface); err != nil {
+		return err
+	}
+	return decodeExpanded(Expand(data, expansion, fields), target)
+}
+
+// ExpandArrayInto expands the data like ExpandArray and decodes the result into the slice the target points to.
+func ExpandArrayInto(data interface{}, expansion, fields string, target interface{}) error {
+	if err := validateTarget(target, reflect.Slice, reflect.Interface); err != nil {
+		return err
+	}
+	expanded := ExpandArray(data, expansion, fields)
+	if expanded == nil {
+		expanded = []interface{}{}
+	}
+	return decodeExpanded(expanded, target)
+}
+
+func validateTarget(target interface{}, kinds ...reflect.Kind) error {
+	v := reflect.ValueOf(target)
+	if !v.IsValid() {
+		return InvalidTargetError{}
+	}
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return InvalidTargetError{v.Type()}
+	}
+	for _, kind := range kinds {
+		if v.Elem().Kind() == kind {
+			return nil
+		}
+	}
+	return InvalidTargetError{v.Type()}
+}
+
+func decodeExpanded(expanded interface{}, target interface{}) error {
+	content, err := json.Marshal(expanded)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(content, target)
+	if typeError, ok := err.(*json.UnmarshalTypeError); ok {
+		return ShapeMismatchError{Field: typeError.Field, Value: typeError.Value, Expected: typeError.Type}
+	}
+	return err
+}
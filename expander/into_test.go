@@ -0,0 +1,78 @@
+package expander
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"reflect"
+	"testing"
+)
+
+type Country struct {
+	Name string `json:"name"`
+}
+
+type ExpandedAddress struct {
+	Street    string
+	Country   Country
+	Neighbors []interface{}
+}
+
+func TestExpandInto(t *testing.T) {
+	Convey("It should decode expanded data into the given target:", t, func() {
+		ClearResolvers()
+		AddResolver(NewInMemoryResolver("countries", reflect.TypeOf(CountryCode("")), map[string]interface{}{
+			"CH": map[string]interface{}{"name": "Switzerland"},
+			"DE": map[string]interface{}{"name": "Germany"},
+		}))
+		address := Address{Street: "Bahnhofstrasse", Country: "CH", Neighbors: []CountryCode{"DE", "XX"}}
+
+		Convey("Reference fields should be decoded into the expanded type or interface{}", func() {
+			var result ExpandedAddress
+
+			err := ExpandInto(address, "*", "", &result)
+
+			So(err, ShouldBeNil)
+			So(result.Street, ShouldEqual, "Bahnhofstrasse")
+			So(result.Country, ShouldResemble, Country{"Switzerland"})
+			So(result.Neighbors, ShouldResemble, []interface{}{map[string]interface{}{"name": "Germany"}, "XX"})
+		})
+
+		Convey("Unexpanded references should be decoded into their own type", func() {
+			var result Address
+
+			err := ExpandInto(address, "", "", &result)
+
+			So(err, ShouldBeNil)
+			So(result, ShouldResemble, address)
+		})
+
+		Convey("A field which does not fit the expanded value should be reported", func() {
+			var result Address
+
+			err := ExpandInto(address, "Country", "", &result)
+
+			So(err, ShouldResemble, ShapeMismatchError{Field: "Country", Value: "object", Expected: reflect.TypeOf(CountryCode(""))})
+			So(err.Error(), ShouldEqual, "expanded value of 'Country' is object and can not be decoded into expander.CountryCode")
+		})
+
+		Convey("Arrays should be decoded into slices", func() {
+			var result []ExpandedAddress
+
+			err := ExpandArrayInto([]Address{address, {Street: "Unter den Linden", Country: "DE"}}, "Country", "", &result)
+
+			So(err, ShouldBeNil)
+			So(len(result), ShouldEqual, 2)
+			So(result[0].Country, ShouldResemble, Country{"Switzerland"})
+			So(result[1].Country, ShouldResemble, Country{"Germany"})
+		})
+
+		Convey("Targets which are not pointers should be refused", func() {
+			var result ExpandedAddress
+			var slice []ExpandedAddress
+
+			So(ExpandInto(address, "*", "", result), ShouldResemble, InvalidTargetError{reflect.TypeOf(result)})
+			So(ExpandInto(address, "*", "", nil), ShouldResemble, InvalidTargetError{})
+			So(ExpandInto(address, "*", "", &slice), ShouldResemble, InvalidTargetError{reflect.TypeOf(&slice)})
+			So(ExpandArrayInto([]Address{address}, "*", "", &result), ShouldResemble, InvalidTargetError{reflect.TypeOf(&result)})
+		})
+	})
+}
@@ -0,0 +1,301 @@
+package expander
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const JSONReferenceKey = "$ref"
+
+var ErrCyclicReference = errors.New("cyclic JSON reference")
+
+// DocumentLoader loads the documents external JSON references point to.
+type DocumentLoader interface {
+	Load(uri string) (interface{}, error)
+}
+
+// ContextDocumentLoader is implemented by loaders which can give up when the context of the resolver is done.
+type ContextDocumentLoader interface {
+	LoadContext(ctx context.Context, uri string) (interface{}, error)
+}
+
+// FileLoader loads documents relative to a directory. References may not leave the directory.
+type FileLoader struct {
+	Dir string
+}
+
+func NewFileLoader(dir string) FileLoader {
+	return FileLoader{Dir: dir}
+}
+
+func (this FileLoader) Load(uri string) (interface{}, error) {
+	path := filepath.Join(this.Dir, filepath.FromSlash(uri))
+	relative, err := filepath.Rel(this.Dir, path)
+	if err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("'%v' is outside of '%v'", uri, this.Dir)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSONDocument(content)
+}
+
+// HttpLoader loads documents from absolute URLs.
+type HttpLoader struct{}
+
+func NewHttpLoader() HttpLoader {
+	return HttpLoader{}
+}
+
+func (this HttpLoader) Load(uri string) (interface{}, error) {
+	return this.LoadContext(context.Background(), uri)
+}
+
+// LoadContext cancels the request when the context is done.
+func (this HttpLoader) LoadContext(ctx context.Context, uri string) (interface{}, error) {
+	parsed, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	content, err := makeGetCall(ctx, parsed)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSONDocument(content)
+}
+
+func decodeJSONDocument(content []byte) (interface{}, error) {
+	var document interface{}
+	if err := json.Unmarshal(content, &document); err != nil {
+		return nil, fmt.Errorf("malformed JSON document: %v", err)
+	}
+	return document, nil
+}
+
+// JSONReferenceResolver resolves JSON References ({"$ref": "#/definitions/x"}, {"$ref": "other.json#/path"}). Local
+// references are resolved against the document being expanded, external ones with the loader. References inside
+// the resolved values are resolved as well; references which lead back into themselves are left untouched, or fail
+// with ErrCyclicReference if they are the expanded reference.
+type JSONReferenceResolver struct {
+	loader DocumentLoader
+}
+
+// NewJSONReferenceResolver creates a resolver for JSON References. Without loader only local references are resolved.
+func NewJSONReferenceResolver(loader DocumentLoader) JSONReferenceResolver {
+	return JSONReferenceResolver{loader: loader}
+}
+
+func (this JSONReferenceResolver) IsReference(t reflect.Value) (Reference, bool) {
+	var reference Reference
+	if !isJSONReference(t) {
+		return reference, false
+	}
+	object, _ := decodedObject(t)
+
+	reference.OriginalReference = object
+	reference.Id = object[JSONReferenceKey].(string)
+	return reference, true
+}
+
+func (this JSONReferenceResolver) MayBeReference(t reflect.Type) bool {
+	return mayBeDecodedObject(t)
+}
+
+// inDocument makes the ids of local references unique per document, so the references of several documents can be
+// resolved in one batch.
+func (this JSONReferenceResolver) inDocument(reference Reference, index int) Reference {
+	if strings.HasPrefix(reference.Id, "#") {
+		reference.Id = UniqueKey(strconv.Itoa(index), reference.Id)
+	}
+	return reference
+}
+
+func (this JSONReferenceResolver) GetName() string {
+	return "JSONReferenceResolver"
+}
+
+func (this JSONReferenceResolver) ResolveRef(refs []Reference) map[string]interface{} {
+	return this.ResolveRefContext(context.Background(), refs)
+}
+
+// ResolveRefContext passes the context on to the loader if it takes one.
+func (this JSONReferenceResolver) ResolveRefContext(ctx context.Context, refs []Reference) map[string]interface{} {
+	resolution := jsonReferenceResolution{ctx: ctx, loader: this.loader, documents: make(map[string]interface{})}
+	callResults := make(map[string]interface{})
+	for _, ref := range refs {
+		if _, ok := callResults[ref.Id]; ok {
+			continue
+		}
+		document, err := toJSONValue(ref.Document)
+		if err != nil {
+			callResults[ref.Id] = err
+			continue
+		}
+		pointer := ref.OriginalReference.(map[string]interface{})[JSONReferenceKey].(string)
+		value, err := resolution.resolve(pointer, document, "", make(map[string]bool))
+		if err != nil {
+			callResults[ref.Id] = err
+			continue
+		}
+		callResults[ref.Id] = value
+	}
+	return callResults
+}
+
+// isJSONReference reports whether the value is a decoded JSON object with a $ref string.
+func isJSONReference(t reflect.Value) bool {
+	object, ok := decodedObject(t)
+	if !ok {
+		return false
+	}
+	_, ok = object[JSONReferenceKey].(string)
+	return ok
+}
+
+type jsonReferenceResolution struct {
+	ctx       context.Context
+	loader    DocumentLoader
+	documents map[string]interface{}
+}
+
+func (this *jsonReferenceResolution) resolve(ref string, document interface{}, documentURI string, visiting map[string]bool) (interface{}, error) {
+	targetURI, fragment := ref, ""
+	if index := strings.Index(ref, "#"); index >= 0 {
+		targetURI, fragment = ref[:index], ref[index+1:]
+	}
+
+	if targetURI != "" {
+		base, err := url.Parse(documentURI)
+		if err != nil {
+			return nil, err
+		}
+		target, err := url.Parse(targetURI)
+		if err != nil {
+			return nil, err
+		}
+		documentURI = base.ResolveReference(target).String()
+		document, err = this.load(documentURI)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	key := documentURI + "#" + fragment
+	if visiting[key] {
+		return nil, ErrCyclicReference
+	}
+	visiting[key] = true
+	defer delete(visiting, key)
+
+	pointer, err := url.PathUnescape(fragment)
+	if err != nil {
+		return nil, err
+	}
+	value, err := evaluateJSONPointer(document, pointer)
+	if err != nil {
+		return nil, err
+	}
+	return this.resolveNested(value, document, documentURI, visiting), nil
+}
+
+// resolveNested copies the value with all JSON references in it resolved. References which can not be resolved are
+// copied as they are.
+func (this *jsonReferenceResolution) resolveNested(value interface{}, document interface{}, documentURI string, visiting map[string]bool) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if ref, ok := value[JSONReferenceKey].(string); ok {
+			resolved, err := this.resolve(ref, document, documentURI, visiting)
+			if err != nil {
+				return value
+			}
+			return resolved
+		}
+		result := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			result[k] = this.resolveNested(v, document, documentURI, visiting)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, v := range value {
+			result[i] = this.resolveNested(v, document, documentURI, visiting)
+		}
+		return result
+	}
+	return value
+}
+
+func (this *jsonReferenceResolution) load(uri string) (interface{}, error) {
+	if document, ok := this.documents[uri]; ok {
+		return document, nil
+	}
+	if this.loader == nil {
+		return nil, fmt.Errorf("no loader for external reference '%v'", uri)
+	}
+	var document interface{}
+	var err error
+	if loader, ok := this.loader.(ContextDocumentLoader); ok {
+		document, err = loader.LoadContext(this.ctx, uri)
+	} else {
+		document, err = this.loader.Load(uri)
+	}
+	if err != nil {
+		return nil, err
+	}
+	this.documents[uri] = document
+	return document, nil
+}
+
+// evaluateJSONPointer returns the value the RFC 6901 JSON Pointer points to.
+func evaluateJSONPointer(document interface{}, pointer string) (interface{}, error) {
+	if pointer == "" {
+		return document, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer '%v'", pointer)
+	}
+
+	current := document
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("JSON pointer '%v' does not exist", pointer)
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index >= len(node) || (len(token) > 1 && token[0] == '0') {
+				return nil, fmt.Errorf("JSON pointer '%v' does not exist", pointer)
+			}
+			current = node[index]
+		default:
+			return nil, fmt.Errorf("JSON pointer '%v' does not exist", pointer)
+		}
+	}
+	return current, nil
+}
+
+// toJSONValue converts the data into the form encoding/json decodes to, so pointers can be evaluated on structs too.
+func toJSONValue(data interface{}) (interface{}, error) {
+	switch data.(type) {
+	case nil, map[string]interface{}, []interface{}:
+		return data, nil
+	}
+	content, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSONDocument(content)
+}
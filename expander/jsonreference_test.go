@@ -0,0 +1,124 @@
+package expander
+
+import (
+	"context"
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJSONPointer(t *testing.T) {
+	Convey("It should evaluate RFC 6901 JSON pointers:", t, func() {
+		var document interface{}
+		json.Unmarshal([]byte(`{"foo": ["bar", "baz"], "": 0, "a/b": 1, "m~n": 8}`), &document)
+
+		cases := map[string]interface{}{
+			"":       document,
+			"/foo":   []interface{}{"bar", "baz"},
+			"/foo/0": "bar",
+			"/":      float64(0),
+			"/a~1b":  float64(1),
+			"/m~0n":  float64(8),
+		}
+		for pointer, expected := range cases {
+			value, err := evaluateJSONPointer(document, pointer)
+			So(err, ShouldBeNil)
+			So(value, ShouldResemble, expected)
+		}
+
+		for _, pointer := range []string{"foo", "/bar", "/foo/2", "/foo/01", "/foo/0/x"} {
+			_, err := evaluateJSONPointer(document, pointer)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestJSONReferenceResolver(t *testing.T) {
+	Convey("It should resolve JSON references of the expanded document and of external documents:", t, func() {
+		ClearResolvers()
+		dir, _ := ioutil.TempDir("", "expander")
+		ioutil.WriteFile(filepath.Join(dir, "other.json"), []byte(`{"path": {"name": "external", "more": {"$ref": "#/more"}}, "more": 42}`), 0644)
+		AddResolver(NewJSONReferenceResolver(NewFileLoader(dir)))
+		decode := func(document string) map[string]interface{} {
+			var result map[string]interface{}
+			json.Unmarshal([]byte(document), &result)
+			return result
+		}
+
+		Convey("Local references should be resolved against the expanded document", func() {
+			config := decode(`{"definitions": {"x": {"size": 1}}, "item": {"$ref": "#/definitions/x"}}`)
+
+			result := Expand(config, "*", "")
+
+			So(result["item"], ShouldResemble, map[string]interface{}{"size": float64(1)})
+		})
+
+		Convey("External references should be loaded and resolved relative to the loaded document", func() {
+			config := decode(`{"item": {"$ref": "other.json#/path"}}`)
+
+			result := Expand(config, "*", "")
+
+			So(result["item"], ShouldResemble, map[string]interface{}{"name": "external", "more": float64(42)})
+		})
+
+		Convey("Only references selected by the expansion filter should be resolved", func() {
+			config := decode(`{"a": {"$ref": "#/c"}, "b": {"$ref": "#/c"}, "c": 1}`)
+
+			result := Expand(config, "a", "")
+
+			So(result["a"], ShouldEqual, float64(1))
+			So(result["b"], ShouldResemble, config["b"])
+		})
+
+		Convey("Cyclic references should not be followed", func() {
+			config := decode(`{"self": {"$ref": "#/self"}, "node": {"name": "n", "parent": {"$ref": "#/node"}}, "item": {"$ref": "#/node"}}`)
+
+			result := Expand(config, "self,item", "")
+
+			So(result["self"], ShouldResemble, config["self"])
+			So(result["item"], ShouldResemble, map[string]interface{}{"name": "n", "parent": map[string]interface{}{"$ref": "#/node"}})
+		})
+
+		Convey("External documents should stop loading when the context of the resolver is done", func() {
+			cancelled := make(chan bool, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+				cancelled <- true
+			}))
+			defer server.Close()
+			config := decode(`{"item": {"$ref": "` + server.URL + `/other.json#/path"}}`)
+			resolver := NewJSONReferenceResolver(NewHttpLoader())
+			ref, _ := resolver.IsReference(reflect.ValueOf(config["item"]))
+			ref.Document = config
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			result := resolver.ResolveRefContext(ctx, []Reference{ref})
+
+			So(result[ref.Id], ShouldImplement, (*error)(nil))
+			select {
+			case <-cancelled:
+			case <-time.After(time.Second):
+				So("the request was not cancelled", ShouldBeEmpty)
+			}
+		})
+
+		Convey("References to files outside of the directory should not be loaded", func() {
+			_, err := NewFileLoader(dir).Load("../secret.json")
+
+			So(err, ShouldNotBeNil)
+		})
+
+		Reset(func() {
+			ClearResolvers()
+			os.RemoveAll(dir)
+		})
+	})
+}
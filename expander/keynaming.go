@@ -0,0 +1,152 @@
+package expander
+
+import (
+	"strings"
+	"unicode"
+)
+
+// KeyNaming names the output keys of struct fields which have no name in their tag. By default they keep their Go
+// name. With ResolvedDocuments the keys of objects returned by resolvers are renamed as well. Keys which do not start
+// with a letter, like _id, @id or $ref, are never renamed.
+type KeyNaming struct {
+	Rename            func(name string) string
+	ResolvedDocuments bool
+}
+
+var GoFieldNames = KeyNaming{}
+
+var keyNaming = GoFieldNames
+
+// keyNamingVersion tells the field cache apart from the fields named by a previous key naming.
+var keyNamingVersion int
+
+func SetKeyNaming(naming KeyNaming) {
+	keyNaming = naming
+	keyNamingVersion++
+}
+
+// CamelCaseKeys names untagged fields in camelCase, e.g. UserId as userId.
+func CamelCaseKeys() KeyNaming {
+	return KeyNaming{Rename: CamelCase}
+}
+
+// SnakeCaseKeys names untagged fields in snake_case, e.g. HTTPServer as http_server.
+func SnakeCaseKeys() KeyNaming {
+	return KeyNaming{Rename: SnakeCase}
+}
+
+func CustomKeys(rename func(name string) string) KeyNaming {
+	return KeyNaming{Rename: rename}
+}
+
+func (this KeyNaming) WithResolvedDocuments() KeyNaming {
+	this.ResolvedDocuments = true
+	return this
+}
+
+func (this KeyNaming) name(key string) string {
+	if this.Rename == nil || key == "" || !unicode.IsLetter([]rune(key)[0]) {
+		return key
+	}
+	return this.Rename(key)
+}
+
+// renameResolved returns a copy of a resolved value with the keys of its objects renamed, if resolved documents are
+// renamed at all. Resolved values are shared by all references to them, so they are never changed in place.
+func (this KeyNaming) renameResolved(value interface{}) interface{} {
+	if this.Rename == nil || !this.ResolvedDocuments {
+		return value
+	}
+	return this.renameKeys(value)
+}
+
+func (this KeyNaming) renameKeys(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(value))
+		for key, child := range value {
+			result[this.name(key)] = this.renameKeys(child)
+		}
+		return result
+	case OrderedMap:
+		result := OrderedMap{Keys: make([]string, len(value.Keys)), Values: make(map[string]interface{}, len(value.Values))}
+		for i, key := range value.Keys {
+			result.Keys[i] = this.name(key)
+			result.Values[result.Keys[i]] = this.renameKeys(value.Values[key])
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, item := range value {
+			result[i] = this.renameKeys(item)
+		}
+		return result
+	case []map[string]interface{}:
+		result := make([]map[string]interface{}, len(value))
+		for i, item := range value {
+			result[i] = this.renameKeys(item).(map[string]interface{})
+		}
+		return result
+	}
+	return value
+}
+
+// CamelCase joins the words of a name, split at underscores, hyphens, spaces and changes of case, to camelCase.
+// Leading acronyms are lowercased as a whole, e.g. ID becomes id and HTTPServer httpServer.
+func CamelCase(name string) string {
+	words := splitWords(name)
+	for i, word := range words {
+		word = strings.ToLower(word)
+		if i > 0 {
+			runes := []rune(word)
+			runes[0] = unicode.ToUpper(runes[0])
+			word = string(runes)
+		}
+		words[i] = word
+	}
+	return strings.Join(words, "")
+}
+
+// SnakeCase joins the lowercased words of a name, split like in CamelCase, with underscores.
+func SnakeCase(name string) string {
+	words := splitWords(name)
+	for i, word := range words {
+		words[i] = strings.ToLower(word)
+	}
+	return strings.Join(words, "_")
+}
+
+// splitWords splits a name at separators and where a lowercase letter or digit is followed by an uppercase letter or
+// an acronym ends, e.g. UserIDs into User, IDs and HTTPServer into HTTP, Server.
+func splitWords(name string) []string {
+	var words []string
+	runes := []rune(name)
+	start := 0
+	for i := 0; i <= len(runes); i++ {
+		if i == len(runes) || runes[i] == '_' || runes[i] == '-' || runes[i] == ' ' {
+			if i > start {
+				words = append(words, string(runes[start:i]))
+			}
+			start = i + 1
+			continue
+		}
+		if i == start || !unicode.IsUpper(runes[i]) {
+			continue
+		}
+		previous := runes[i-1]
+		if unicode.IsLower(previous) || unicode.IsDigit(previous) || (unicode.IsUpper(previous) && startsWord(runes[i+1:])) {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+	return words
+}
+
+// startsWord reports whether the rest of a name after an uppercase letter continues in lowercase, apart from the
+// plural of an acronym like IDs.
+func startsWord(rest []rune) bool {
+	if len(rest) == 0 || !unicode.IsLower(rest[0]) {
+		return false
+	}
+	return rest[0] != 's' || (len(rest) > 1 && unicode.IsLower(rest[1]))
+}
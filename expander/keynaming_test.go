@@ -0,0 +1,114 @@
+package expander
+
+import (
+	"context"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type NamedPost struct {
+	Title      string
+	HTTPStatus int
+	ReaderIDs  []string
+	AuthorId   DBRef `json:"authorId"`
+	EditorRef  DBRef
+}
+
+func TestKeyNaming(t *testing.T) {
+	Convey("It should convert names between cases:", t, func() {
+		cases := []struct {
+			name, camel, snake string
+		}{
+			{"Title", "title", "title"},
+			{"UserId", "userId", "user_id"},
+			{"ID", "id", "id"},
+			{"HTTPServer", "httpServer", "http_server"},
+			{"ReaderIDs", "readerIds", "reader_ids"},
+			{"Address2Line", "address2Line", "address2_line"},
+			{"created_at", "createdAt", "created_at"},
+			{"first-name", "firstName", "first_name"},
+		}
+
+		for _, c := range cases {
+			So(CamelCase(c.name), ShouldEqual, c.camel)
+			So(SnakeCase(c.name), ShouldEqual, c.snake)
+		}
+	})
+
+	Convey("It should name output keys by the key naming:", t, func() {
+		ClearResolvers()
+		mockedFn := makeGetCall
+		makeGetCall = func(ctx context.Context, murl *url.URL) ([]byte, error) {
+			return []byte(`{"_id":"123","FullName":"A name","home_address":{"ZipCode":"8000"}}`), nil
+		}
+		AddResolver(NewMongoDbRefResolver(map[string]string{"authors": "http://some-uri/id/"}, false))
+		ref := DBRef{"authors", MongoId("123"), "a database"}
+		post := NamedPost{Title: "A title", HTTPStatus: 200, ReaderIDs: []string{"1"}, AuthorId: ref, EditorRef: ref}
+
+		Reset(func() {
+			makeGetCall = mockedFn
+			SetKeyNaming(GoFieldNames)
+		})
+
+		Convey("Untagged fields should keep their Go name by default", func() {
+			result := Expand(post, "", "")
+
+			So(result, ShouldContainKey, "Title")
+			So(result, ShouldContainKey, "HTTPStatus")
+			So(result, ShouldContainKey, "authorId")
+		})
+
+		Convey("Untagged fields should be named in camelCase or snake_case, tagged ones keep their name", func() {
+			SetKeyNaming(CamelCaseKeys())
+			result := Expand(post, "", "title,httpStatus,readerIds,authorId")
+
+			So(result, ShouldResemble, map[string]interface{}{
+				"title":      "A title",
+				"httpStatus": int64(200),
+				"readerIds":  []interface{}{"1"},
+				"authorId":   ref,
+			})
+
+			SetKeyNaming(SnakeCaseKeys())
+			result = Expand(post, "", "")
+
+			So(result, ShouldContainKey, "http_status")
+			So(result, ShouldContainKey, "editor_ref")
+		})
+
+		Convey("Custom names should be used", func() {
+			SetKeyNaming(CustomKeys(strings.ToUpper))
+			result := Expand(post, "", "")
+
+			So(result, ShouldContainKey, "TITLE")
+			So(result, ShouldContainKey, "READERIDS")
+		})
+
+		Convey("Resolved documents should keep their keys unless they are renamed as well", func() {
+			SetKeyNaming(CamelCaseKeys())
+			result := Expand(post, "*", "")
+
+			So(result["editorRef"], ShouldResemble, map[string]interface{}{
+				"_id": "123", "FullName": "A name", "home_address": map[string]interface{}{"ZipCode": "8000"},
+			})
+
+			SetKeyNaming(CamelCaseKeys().WithResolvedDocuments())
+			result = Expand(post, "*", "")
+			expected := map[string]interface{}{
+				"_id": "123", "fullName": "A name", "homeAddress": map[string]interface{}{"zipCode": "8000"},
+			}
+
+			So(result["editorRef"], ShouldResemble, expected)
+			So(result["authorId"], ShouldResemble, expected)
+		})
+
+		Convey("Ordered output should keep the order of renamed fields", func() {
+			SetKeyNaming(SnakeCaseKeys())
+			ordered := ExpandOrdered(post, "", "")
+
+			So(ordered.Keys, ShouldResemble, []string{"title", "http_status", "reader_ids", "authorId", "editor_ref"})
+		})
+	})
+}
@@ -0,0 +1,179 @@
+package expander
+
+import "reflect"
+
+const DefaultNestKey = "_expanded"
+
+// KeyStrategy decides under which key a resolved value is written. By default it replaces the reference. With a
+// sibling function the reference is kept and the resolved value is added under the returned key, with a nest key
+// it is added to an object under that key instead. The root has no key of its own, so it keeps its fields and gets
+// the resolved document under the nest key, or DefaultNestKey, whenever the reference is not replaced.
+type KeyStrategy struct {
+	Sibling func(key string) string
+	NestKey string
+}
+
+var ReplaceKeys = KeyStrategy{}
+
+var keyStrategy = ReplaceKeys
+
+func SetKeyStrategy(strategy KeyStrategy) {
+	keyStrategy = strategy
+}
+
+// SiblingKeys adds resolved values next to the reference, e.g. strings.TrimSuffix(key, "Id") keeps authorId and adds
+// author.
+func SiblingKeys(rename func(key string) string) KeyStrategy {
+	return KeyStrategy{Sibling: rename}
+}
+
+func SiblingKeysWithPrefix(prefix string) KeyStrategy {
+	return SiblingKeys(func(key string) string {
+		return prefix + key
+	})
+}
+
+func SiblingKeysWithSuffix(suffix string) KeyStrategy {
+	return SiblingKeys(func(key string) string {
+		return key + suffix
+	})
+}
+
+func NestedKeys(nestKey string) KeyStrategy {
+	return KeyStrategy{NestKey: nestKey}
+}
+
+func (this KeyStrategy) replaces() bool {
+	return this.Sibling == nil && this.NestKey == ""
+}
+
+func (this KeyStrategy) rootNestKey() string {
+	if this.NestKey != "" {
+		return this.NestKey
+	}
+	return DefaultNestKey
+}
+
+// expandedValue marks a resolved or failed reference in the walked data until the key strategy is applied.
+type expandedValue struct {
+	original  interface{}
+	value     interface{}
+	err       error
+	omitempty bool
+}
+
+func (this expandedValue) resolved() interface{} {
+	if this.err != nil {
+		return failedValue(this.original, this.err)
+	}
+	return this.value
+}
+
+// applyKeyStrategy replaces all expanded values in the data with the resolved value, and the reference where it
+// is kept, and writes them under the keys given by the key strategy.
+func applyKeyStrategy(data map[string]interface{}) map[string]interface{} {
+	if data == nil {
+		return data
+	}
+
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+
+	for _, key := range keys {
+		value := data[key]
+		if !containsExpandedValue(value) {
+			data[key] = applyKeyStrategyToChildren(value)
+			continue
+		}
+
+		resolved := resolvedValues(value)
+		if marker, ok := value.(expandedValue); ok && marker.omitempty && isEmptyValue(reflect.ValueOf(resolved)) {
+			delete(data, key)
+			continue
+		}
+		if keyStrategy.replaces() {
+			data[key] = resolved
+			continue
+		}
+
+		data[key] = originalValues(value)
+		if marker, ok := value.(expandedValue); ok && marker.err != nil && errorPolicy == KeepReference {
+			continue
+		}
+		if keyStrategy.NestKey != "" {
+			nested, ok := data[keyStrategy.NestKey].(map[string]interface{})
+			if !ok {
+				nested = make(map[string]interface{})
+				data[keyStrategy.NestKey] = nested
+			}
+			nested[key] = resolved
+		} else if siblingKey := keyStrategy.Sibling(key); siblingKey != key {
+			data[siblingKey] = resolved
+		} else {
+			data[key] = resolved
+		}
+	}
+	return data
+}
+
+func applyKeyStrategyToChildren(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return applyKeyStrategy(value)
+	case []map[string]interface{}:
+		for i := range value {
+			value[i] = applyKeyStrategy(value[i])
+		}
+	case []interface{}:
+		for i := range value {
+			value[i] = applyKeyStrategyToChildren(value[i])
+		}
+	}
+	return value
+}
+
+// containsExpandedValue reports whether the value is an expanded value or a slice holding some, directly or in
+// nested slices.
+func containsExpandedValue(value interface{}) bool {
+	switch value := value.(type) {
+	case expandedValue:
+		return true
+	case []interface{}:
+		for _, item := range value {
+			if containsExpandedValue(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func resolvedValues(value interface{}) interface{} {
+	switch value := value.(type) {
+	case expandedValue:
+		return value.resolved()
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, item := range value {
+			result[i] = resolvedValues(item)
+		}
+		return result
+	}
+	return applyKeyStrategyToChildren(value)
+}
+
+func originalValues(value interface{}) interface{} {
+	switch value := value.(type) {
+	case expandedValue:
+		return value.original
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, item := range value {
+			result[i] = originalValues(item)
+		}
+		return result
+	}
+	return applyKeyStrategyToChildren(value)
+}
@@ -0,0 +1,98 @@
+package expander
+
+import (
+	"context"
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type PostWithRefs struct {
+	Title    string
+	AuthorId DBRef   `json:"authorId"`
+	Readers  []DBRef `json:"readers"`
+}
+
+func TestKeyStrategy(t *testing.T) {
+	Convey("It should write resolved values under the keys given by the key strategy:", t, func() {
+		ClearResolvers()
+		info := Info{"A name", 100}
+		mockedFn := makeGetCall
+		makeGetCall = func(ctx context.Context, murl *url.URL) ([]byte, error) {
+			result, _ := json.Marshal(info)
+			return result, nil
+		}
+		AddResolver(NewMongoDbRefResolver(map[string]string{"a collection": "http://some-uri/id/"}, false))
+		ref := DBRef{"a collection", MongoId("123"), "a database"}
+		post := PostWithRefs{Title: "A title", AuthorId: ref, Readers: []DBRef{ref}}
+		expectedInfo := map[string]interface{}{"Name": "A name", "Age": float64(100)}
+
+		Convey("References should be replaced by default", func() {
+			result := Expand(post, "*", "")
+
+			So(result["authorId"], ShouldResemble, expectedInfo)
+			So(result["readers"], ShouldResemble, []interface{}{expectedInfo})
+		})
+
+		Convey("Sibling keys should keep the reference and add the resolved value", func() {
+			SetKeyStrategy(SiblingKeys(func(key string) string {
+				return strings.TrimSuffix(key, "Id")
+			}))
+
+			result := Expand(post, "*", "")
+
+			So(result["authorId"], ShouldResemble, ref)
+			So(result["author"], ShouldResemble, expectedInfo)
+			So(result["readers"], ShouldResemble, []interface{}{expectedInfo})
+		})
+
+		Convey("Sibling keys with suffix should apply to slices", func() {
+			SetKeyStrategy(SiblingKeysWithSuffix("Expanded"))
+
+			result := Expand(post, "*", "")
+
+			So(result["readers"], ShouldResemble, []interface{}{ref})
+			So(result["readersExpanded"], ShouldResemble, []interface{}{expectedInfo})
+			So(result["authorIdExpanded"], ShouldResemble, expectedInfo)
+		})
+
+		Convey("Nested keys should collect the resolved values in one object", func() {
+			SetKeyStrategy(NestedKeys(DefaultNestKey))
+
+			result := Expand(post, "*", "")
+			expanded := result["_expanded"].(map[string]interface{})
+
+			So(result["authorId"], ShouldResemble, ref)
+			So(expanded["authorId"], ShouldResemble, expectedInfo)
+			So(expanded["readers"], ShouldResemble, []interface{}{expectedInfo})
+		})
+
+		Convey("Root references should keep their fields and nest the resolved document", func() {
+			SetKeyStrategy(SiblingKeysWithPrefix("expanded"))
+
+			result := ExpandArray([]DBRef{ref}, "*", "")
+			item := result[0].(map[string]interface{})
+
+			So(item["Collection"], ShouldEqual, ref.Collection)
+			So(item[DefaultNestKey], ShouldResemble, expectedInfo)
+		})
+
+		Convey("Failed references should not be duplicated when they are kept", func() {
+			SetKeyStrategy(SiblingKeysWithSuffix("Expanded"))
+			post.AuthorId = DBRef{"unknown collection", MongoId("456"), "a database"}
+
+			result := Expand(post, "*", "")
+
+			So(result["authorId"], ShouldResemble, post.AuthorId)
+			So(result, ShouldNotContainKey, "authorIdExpanded")
+		})
+
+		Reset(func() {
+			SetKeyStrategy(ReplaceKeys)
+			ClearResolvers()
+			makeGetCall = mockedFn
+		})
+	})
+}
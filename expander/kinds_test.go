@@ -0,0 +1,85 @@
+package expander
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"reflect"
+	"testing"
+)
+
+type KindsHolder struct {
+	Value interface{}
+}
+
+type NilPointers struct {
+	Name    *string
+	Address *Address
+	Codes   []CountryCode
+	Rates   map[string]float64
+	Price   *Price
+	Version *Version
+}
+
+func TestKinds(t *testing.T) {
+	Convey("It should walk every kind like encoding/json:", t, func() {
+		ClearResolvers()
+		name := "a name"
+
+		cases := []struct {
+			name  string
+			value interface{}
+		}{
+			{"maps with int keys", KindsHolder{map[int]string{1: "one", -2: "minus two"}}},
+			{"maps with uint keys", KindsHolder{map[uint8]bool{8: true}}},
+			{"maps with text marshaler keys", KindsHolder{map[Currency]int{"chf": 1}}},
+			{"arrays", KindsHolder{[3]int{1, 2, 3}}},
+			{"arrays of structs", KindsHolder{[1]Address{{Street: "Bahnhofstrasse"}}}},
+			{"byte slices", KindsHolder{[]byte("some bytes")}},
+			{"interfaces holding structs", KindsHolder{Address{Street: "Bahnhofstrasse", Country: "CH"}}},
+			{"interfaces holding pointers", KindsHolder{&name}},
+			{"nil interfaces", KindsHolder{}},
+			{"slices of pointers", KindsHolder{[]*string{&name, nil}}},
+			{"nil pointers, slices and maps", NilPointers{}},
+			{"set pointers", NilPointers{Name: &name, Address: &Address{Street: "Bahnhofstrasse"}, Version: &Version{1, 2}}},
+		}
+
+		for _, c := range cases {
+			Convey("for "+c.name, func() {
+				So(toJSONValues(Expand(c.value, "", "")), ShouldResemble, toJSONValues(c.value))
+			})
+		}
+	})
+
+	Convey("It should detect references behind interfaces, pointers and in arrays:", t, func() {
+		ClearResolvers()
+		switzerland := map[string]interface{}{"name": "Switzerland"}
+		AddResolver(NewInMemoryResolver("countries", reflect.TypeOf(CountryCode("")), map[string]interface{}{"CH": switzerland}))
+		country := CountryCode("CH")
+
+		cases := []struct {
+			name     string
+			value    interface{}
+			expected interface{}
+		}{
+			{"an interface field holding a struct", KindsHolder{Address{Country: "CH"}}, switzerland},
+			{"an interface field holding a reference", KindsHolder{country}, switzerland},
+			{"a pointer to a reference", KindsHolder{&country}, switzerland},
+			{"an array of references", KindsHolder{[2]CountryCode{"CH", "XX"}}, []interface{}{switzerland, CountryCode("XX")}},
+			{"a slice of interfaces", KindsHolder{[]interface{}{country, nil}}, []interface{}{switzerland, nil}},
+		}
+
+		for _, c := range cases {
+			Convey("for "+c.name, func() {
+				result := Expand(c.value, "*", "")["Value"]
+				if address, ok := result.(map[string]interface{}); ok && address["Country"] != nil {
+					result = address["Country"]
+				}
+				So(result, ShouldResemble, c.expected)
+			})
+		}
+
+		Convey("for a nil pointer at the root", func() {
+			var address *Address
+			So(Expand(address, "*", ""), ShouldResemble, map[string]interface{}{})
+		})
+	})
+}
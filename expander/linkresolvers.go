@@ -0,0 +1,172 @@
+package expander
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sync"
+)
+
+const (
+	HALLinksKey    = "_links"
+	HALEmbeddedKey = "_embedded"
+	JSONLDIdKey    = "@id"
+)
+
+// HALResolver resolves HAL link objects ({"href": "..."}) found under _links of decoded JSON. The walker embeds the
+// linked resources under _embedded, keyed by link relation; the expansion filter selects relations by name.
+type HALResolver struct{}
+
+// halLink marks a link object found under _links. Objects with an href anywhere else are plain data and are never
+// passed to the HALResolver in this form.
+type halLink struct {
+	link map[string]interface{}
+}
+
+func NewHALResolver() HALResolver {
+	return HALResolver{}
+}
+
+func (this HALResolver) IsReference(t reflect.Value) (Reference, bool) {
+	var reference Reference
+	if !t.IsValid() || t.Type() != reflect.TypeOf(halLink{}) {
+		return reference, false
+	}
+	link := t.Interface().(halLink).link
+	href, ok := link["href"].(string)
+	if !ok || href == "" || link["templated"] == true {
+		return reference, false
+	}
+
+	reference.OriginalReference = link
+	reference.Id = href
+	return reference, true
+}
+
+func (this HALResolver) MayBeReference(t reflect.Type) bool {
+	return t == reflect.TypeOf(halLink{})
+}
+
+func (this HALResolver) ResolveRef(refs []Reference) map[string]interface{} {
+	return fetchLinks(context.Background(), refs)
+}
+
+func (this HALResolver) ResolveRefContext(ctx context.Context, refs []Reference) map[string]interface{} {
+	return fetchLinks(ctx, refs)
+}
+
+func (this HALResolver) GetName() string {
+	return "HALResolver"
+}
+
+// JSONLDResolver resolves JSON-LD node references ({"@id": "..."}) of decoded JSON and replaces them with the
+// linked resources in place.
+type JSONLDResolver struct{}
+
+func NewJSONLDResolver() JSONLDResolver {
+	return JSONLDResolver{}
+}
+
+func (this JSONLDResolver) IsReference(t reflect.Value) (Reference, bool) {
+	var reference Reference
+	if !isNodeReference(t) {
+		return reference, false
+	}
+	node, _ := decodedObject(t)
+
+	reference.OriginalReference = node
+	reference.Id = node[JSONLDIdKey].(string)
+	return reference, true
+}
+
+func (this JSONLDResolver) MayBeReference(t reflect.Type) bool {
+	return mayBeDecodedObject(t)
+}
+
+func (this JSONLDResolver) ResolveRef(refs []Reference) map[string]interface{} {
+	return fetchLinks(context.Background(), refs)
+}
+
+func (this JSONLDResolver) ResolveRefContext(ctx context.Context, refs []Reference) map[string]interface{} {
+	return fetchLinks(ctx, refs)
+}
+
+func (this JSONLDResolver) GetName() string {
+	return "JSONLDResolver"
+}
+
+// isNodeReference reports whether the value is a JSON-LD object which consists of nothing but an @id.
+func isNodeReference(t reflect.Value) bool {
+	node, ok := decodedObject(t)
+	if !ok || len(node) != 1 {
+		return false
+	}
+	id, ok := node[JSONLDIdKey].(string)
+	return ok && id != ""
+}
+
+// mayBeDecodedObject reports whether values of the type may hold an object of decoded JSON.
+func mayBeDecodedObject(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		return true
+	}
+	return t == reflect.TypeOf(map[string]interface{}{})
+}
+
+func decodedObject(t reflect.Value) (map[string]interface{}, bool) {
+	for t.IsValid() && (t.Kind() == reflect.Interface || t.Kind() == reflect.Ptr) && !t.IsNil() {
+		t = t.Elem()
+	}
+	if !t.IsValid() || t.Kind() != reflect.Map {
+		return nil, false
+	}
+	object, ok := t.Interface().(map[string]interface{})
+	return object, ok
+}
+
+// fetchLinks fetches every distinct URL once. URLs of the same host are fetched one after the other so the
+// connection is reused, different hosts are fetched in parallel.
+func fetchLinks(ctx context.Context, refs []Reference) map[string]interface{} {
+	perHostURLs := make(map[string][]string)
+	seen := make(map[string]bool)
+	callResults := make(map[string]interface{})
+	for _, ref := range refs {
+		if seen[ref.Id] {
+			continue
+		}
+		seen[ref.Id] = true
+		uri, err := url.ParseRequestURI(ref.Id)
+		if err != nil || uri.Host == "" {
+			callResults[ref.Id] = fmt.Errorf("'%v' is not an absolute URL", ref.Id)
+			continue
+		}
+		perHostURLs[uri.Host] = append(perHostURLs[uri.Host], ref.Id)
+	}
+
+	var mutex sync.Mutex
+	var wait sync.WaitGroup
+	for _, urls := range perHostURLs {
+		wait.Add(1)
+		go func(urls []string) {
+			defer wait.Done()
+			for _, link := range urls {
+				var result interface{}
+				uri, _ := url.ParseRequestURI(link)
+				responseBytes, err := makeGetCall(ctx, uri)
+				if err == nil {
+					result, err = decodeDocument(responseBytes)
+				}
+				if err != nil {
+					result = err
+				}
+				mutex.Lock()
+				callResults[link] = result
+				mutex.Unlock()
+			}
+		}(urls)
+	}
+	wait.Wait()
+	return callResults
+}
@@ -0,0 +1,100 @@
+package expander
+
+import (
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLinkResolvers(t *testing.T) {
+	Convey("It should resolve HAL and JSON-LD links of decoded JSON:", t, func() {
+		ClearResolvers()
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.Header().Set("Content-Type", "application/hal+json")
+			w.Write([]byte(`{"path": "` + r.URL.Path + `"}`))
+		}))
+		decode := func(document string) map[string]interface{} {
+			var result map[string]interface{}
+			json.Unmarshal([]byte(document), &result)
+			return result
+		}
+
+		Convey("HAL links should be embedded by relation when selected by the expansion filter", func() {
+			AddResolver(NewHALResolver())
+			order := decode(`{"id": 1, "_links": {
+				"self": {"href": "` + server.URL + `/orders/1"},
+				"customer": {"href": "` + server.URL + `/customers/7"},
+				"items": [{"href": "` + server.URL + `/items/1"}, {"href": "` + server.URL + `/items/2"}]
+			}}`)
+
+			result := Expand(order, "customer", "")
+
+			embedded := result[HALEmbeddedKey].(map[string]interface{})
+			So(embedded["customer"], ShouldResemble, map[string]interface{}{"path": "/customers/7"})
+			So(embedded, ShouldNotContainKey, "items")
+			So(result[HALLinksKey], ShouldResemble, order[HALLinksKey])
+			So(calls, ShouldEqual, 1)
+		})
+
+		Convey("HAL links should all be embedded except self when expanding everything", func() {
+			AddResolver(NewHALResolver())
+			order := decode(`{"id": 1, "_links": {
+				"self": {"href": "` + server.URL + `/orders/1"},
+				"items": [{"href": "` + server.URL + `/items/1"}, {"href": "` + server.URL + `/items/1"}]
+			}}`)
+
+			result := Expand(order, "*", "")
+
+			embedded := result[HALEmbeddedKey].(map[string]interface{})
+			item := map[string]interface{}{"path": "/items/1"}
+			So(embedded["items"], ShouldResemble, []interface{}{item, item})
+			So(embedded, ShouldNotContainKey, "self")
+			So(calls, ShouldEqual, 1)
+		})
+
+		Convey("Objects with an href outside of _links should be left alone", func() {
+			AddResolver(NewHALResolver())
+			product := decode(`{"image": {"href": "http://cdn/x.png"}, "_links": {"self": {"href": "` + server.URL + `/products/1"}}}`)
+
+			result := Expand(product, "*", "")
+
+			So(result["image"], ShouldResemble, product["image"])
+			So(result, ShouldNotContainKey, HALEmbeddedKey)
+			So(calls, ShouldEqual, 0)
+		})
+
+		Convey("JSON-LD node references should be replaced in place", func() {
+			AddResolver(NewJSONLDResolver())
+			book := decode(`{"@id": "` + server.URL + `/books/1", "name": "A title",
+				"author": {"@id": "` + server.URL + `/people/1"},
+				"publisher": {"@id": "` + server.URL + `/publishers/1"}}`)
+
+			result := Expand(book, "author", "")
+
+			So(result["author"], ShouldResemble, map[string]interface{}{"path": "/people/1"})
+			So(result["publisher"], ShouldResemble, book["publisher"])
+			So(result["@id"], ShouldEqual, book["@id"])
+		})
+
+		Convey("Links which are no absolute URLs should take the error path", func() {
+			AddResolver(NewJSONLDResolver())
+			SetErrorPolicy(NullOnError)
+			book := decode(`{"author": {"@id": "people/1"}}`)
+
+			result := Expand(book, "*", "")
+
+			So(result["author"], ShouldBeNil)
+			SetErrorPolicy(KeepReference)
+		})
+
+		Reset(func() {
+			ClearResolvers()
+			server.Close()
+		})
+	})
+}
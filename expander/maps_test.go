@@ -0,0 +1,95 @@
+package expander
+
+import (
+	"context"
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type DocumentWithLinks struct {
+	Title string
+	Links map[string]DBRef `json:"links"`
+}
+
+type RegionWithCountries struct {
+	Name      string
+	Countries map[string]interface{}
+}
+
+func TestMapReferences(t *testing.T) {
+	Convey("It should expand references held by maps:", t, func() {
+		ClearResolvers()
+		mockedFn := makeGetCall
+		makeGetCall = func(ctx context.Context, murl *url.URL) ([]byte, error) {
+			id := murl.Path[strings.LastIndex(murl.Path, "/")+1:]
+			return json.Marshal(map[string]interface{}{"name": id})
+		}
+		AddResolver(NewMongoDbRefResolver(map[string]string{"users": "http://some-uri/id/"}, false))
+		owner := DBRef{"users", MongoId("owner"), "a database"}
+		editor := DBRef{"users", MongoId("editor"), "a database"}
+		document := DocumentWithLinks{Title: "A title", Links: map[string]DBRef{"owner": owner, "editor": editor}}
+		// references which are not expanded are walked like any other value
+		walked := func(id string) map[string]interface{} {
+			return map[string]interface{}{"Collection": "users", "Id": id, "Database": "a database"}
+		}
+
+		Reset(func() {
+			makeGetCall = mockedFn
+			SetKeyStrategy(ReplaceKeys)
+		})
+
+		Convey("All references of a selected map should be expanded", func() {
+			So(Expand(document, "links", "")["links"], ShouldResemble, map[string]interface{}{
+				"owner":  map[string]interface{}{"name": "owner"},
+				"editor": map[string]interface{}{"name": "editor"},
+			})
+			So(Expand(document, "*", "")["links"], ShouldResemble, Expand(document, "links", "")["links"])
+		})
+
+		Convey("Only the references under selected keys should be expanded", func() {
+			So(Expand(document, "links(owner)", "")["links"], ShouldResemble, map[string]interface{}{
+				"owner":  map[string]interface{}{"name": "owner"},
+				"editor": walked("editor"),
+			})
+		})
+
+		Convey("References of maps which are not selected should not be expanded", func() {
+			So(Expand(document, "Title", "")["links"], ShouldResemble, map[string]interface{}{"owner": walked("owner"), "editor": walked("editor")})
+		})
+
+		Convey("Resolved values should be written by the key strategy", func() {
+			SetKeyStrategy(SiblingKeysWithSuffix("Expanded"))
+
+			So(Expand(document, "links(editor)", "")["links"], ShouldResemble, map[string]interface{}{
+				"owner":          walked("owner"),
+				"editor":         editor,
+				"editorExpanded": map[string]interface{}{"name": "editor"},
+			})
+		})
+
+		Convey("References in nested maps and root maps should be expanded by their keys", func() {
+			nested := map[string]interface{}{
+				"team":  map[string]interface{}{"lead": &owner, "member": editor},
+				"owner": owner,
+			}
+
+			So(Expand(nested, "team(lead)", ""), ShouldResemble, map[string]interface{}{
+				"team":  map[string]interface{}{"lead": map[string]interface{}{"name": "owner"}, "member": walked("editor")},
+				"owner": walked("owner"),
+			})
+		})
+	})
+
+	Convey("It should detect references of other resolvers in maps:", t, func() {
+		ClearResolvers()
+		switzerland := map[string]interface{}{"name": "Switzerland"}
+		AddResolver(NewInMemoryResolver("countries", reflect.TypeOf(CountryCode("")), map[string]interface{}{"CH": switzerland}))
+		region := RegionWithCountries{Name: "Alps", Countries: map[string]interface{}{"home": CountryCode("CH"), "code": "CH"}}
+
+		So(Expand(region, "Countries", "")["Countries"], ShouldResemble, map[string]interface{}{"home": switzerland, "code": "CH"})
+	})
+}
@@ -0,0 +1,95 @@
+package expander
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+	"time"
+)
+
+type GeoPoint struct {
+	Lat, Lng float64
+}
+
+func (this GeoPoint) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(`{"type":"Point","coordinates":[%v,%v]}`, this.Lng, this.Lat)), nil
+}
+
+type Version struct {
+	Major, Minor int
+}
+
+func (this *Version) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(`"v%v.%v"`, this.Major, this.Minor)), nil
+}
+
+type Currency string
+
+func (this Currency) MarshalText() ([]byte, error) {
+	if this == "" {
+		return nil, errors.New("no currency")
+	}
+	return []byte(strings.ToUpper(string(this))), nil
+}
+
+type Price struct {
+	Amount   float64
+	Currency Currency
+	Location GeoPoint
+	Version  Version
+	Rates    map[Currency]float64
+	Created  time.Time `json:",omitempty"`
+	Updated  time.Time `json:",omitempty"`
+	Label    string    `json:",omitempty"`
+}
+
+func TestMarshalers(t *testing.T) {
+	Convey("It should use the JSON of values which marshal themselves:", t, func() {
+		ClearResolvers()
+		price := Price{
+			Amount:   9.5,
+			Currency: "chf",
+			Location: GeoPoint{47.37, 8.54},
+			Version:  Version{1, 2},
+			Rates:    map[Currency]float64{"eur": 0.95, "usd": 1.1},
+			Updated:  time.Date(2014, 8, 1, 12, 0, 0, 0, time.UTC),
+			Label:    "0001-01-01T00:00:00Z",
+		}
+
+		Convey("A json.Marshaler returning an object should become an object", func() {
+			result := Expand(price, "", "")
+
+			So(result["Location"], ShouldResemble, map[string]interface{}{"type": "Point", "coordinates": []interface{}{8.54, 47.37}})
+		})
+
+		Convey("Text marshalers should be used for values and map keys", func() {
+			result := Expand(price, "", "")
+
+			So(result["Currency"], ShouldEqual, "CHF")
+			So(result["Rates"], ShouldResemble, map[string]interface{}{"EUR": 0.95, "USD": 1.1})
+		})
+
+		Convey("Zero times should be omitted by type, not by their string", func() {
+			result := Expand(price, "", "")
+
+			_, hasCreated := result["Created"]
+			So(hasCreated, ShouldBeFalse)
+			So(result["Updated"], ShouldEqual, "2014-08-01T12:00:00Z")
+			So(result["Label"], ShouldEqual, "0001-01-01T00:00:00Z")
+		})
+
+		Convey("Marshalers with pointer receivers should be used for addressable values only", func() {
+			So(Expand(&price, "", "")["Version"], ShouldEqual, "v1.2")
+			So(Expand(price, "", "")["Version"], ShouldResemble, map[string]interface{}{"Major": int64(1), "Minor": int64(2)})
+		})
+
+		Convey("The result should be the JSON of encoding/json apart from omitted zero times", func() {
+			price.Created = price.Updated
+
+			So(toJSONValues(Expand(price, "", "")), ShouldResemble, toJSONValues(price))
+			So(toJSONValues(Expand(&price, "", "")), ShouldResemble, toJSONValues(&price))
+		})
+	})
+}
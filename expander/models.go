@@ -1,6 +1,9 @@
 package expander
 
-import "reflect"
+import (
+	"context"
+	"reflect"
+)
 
 type Configuration struct {
 	Resolvers []Resolver
@@ -62,22 +65,65 @@ type ExpansionTask struct {
 	Resolver  string
 	Reference Reference
 	Success   func(value interface{})
-	Error     func()
+	Error     func(err error)
+}
+
+// ExpansionReport lists the references which were left unresolved because a resolver exceeded its timeout
+// or the expansion ran out of time.
+type ExpansionReport struct {
+	TimedOut []Reference
 }
 
 type Reference struct {
 	Id                string
 	OriginalReference interface{}
+	// Document is the data being expanded, for references which are relative to it.
+	Document interface{}
 }
 
+// Resolver detects references and resolves them in bulk. ResolveRef returns the resolved values keyed by
+// Reference.Id; a reference which could not be resolved is either left out or mapped to an error.
 type Resolver interface {
 	IsReference(reflect.Value) (Reference, bool)
 	ResolveRef([]Reference) map[string]interface{}
 	GetName() string
 }
 
+// ContextResolver is implemented by resolvers which can give up when the context is done. The context of a call ends
+// when the resolver timeout or the expansion timeout passes, so slow requests are cancelled instead of left running.
+type ContextResolver interface {
+	ResolveRefContext(ctx context.Context, refs []Reference) map[string]interface{}
+}
+
+// resolveRef resolves the references with the context if the resolver takes one.
+func resolveRef(ctx context.Context, resolver Resolver, refs []Reference) map[string]interface{} {
+	if contextResolver, ok := resolver.(ContextResolver); ok {
+		return contextResolver.ResolveRefContext(ctx, refs)
+	}
+	return resolver.ResolveRef(refs)
+}
+
+// TypeFilter is implemented by resolvers which recognize references by their type. The walker asks them for values
+// of types they may detect only, and remembers the answer per type.
+type TypeFilter interface {
+	MayBeReference(t reflect.Type) bool
+}
+
+// documentRelative is implemented by resolvers whose references are relative to the document they are found in, like
+// local JSON references. Before the references of several documents are resolved together, each reference is passed
+// with the position of its document to make its id unique.
+type documentRelative interface {
+	inDocument(reference Reference, index int) Reference
+}
+
 type WalkStateHolder struct {
 	resolveTasks *[]ExpansionTask
+	document     interface{}
+	resolvers    *resolverSet
+}
+
+func newWalkStateHolder(document interface{}, resolvers *resolverSet) WalkStateHolder {
+	return WalkStateHolder{&[]ExpansionTask{}, document, resolvers}
 }
 
 func (this *WalkStateHolder) GetExpansionTasks() []ExpansionTask {
@@ -85,6 +131,9 @@ func (this *WalkStateHolder) GetExpansionTasks() []ExpansionTask {
 }
 
 func (this *WalkStateHolder) AddExpansionTask(resolveTask ExpansionTask) {
+	if resolveTask.Reference.Document == nil {
+		resolveTask.Reference.Document = this.document
+	}
 	realArray := *this.resolveTasks
 	result := append(realArray, resolveTask)
 	*this.resolveTasks = result
@@ -93,15 +142,3 @@ func (this *WalkStateHolder) AddExpansionTask(resolveTask ExpansionTask) {
 func UniqueKey(collection string, id string) string {
 	return collection + "." + id
 }
-
-type MongoObject struct {
-	Id string `json:"_id"`
-}
-
-type BulkResponseMongoObject struct {
-	Data []MongoObject `json:"data"`
-}
-
-type BulkResponseData struct {
-	Data []map[string]interface{} `json:"data"`
-}
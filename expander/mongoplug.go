@@ -1,22 +1,77 @@
 package expander
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/url"
 	"reflect"
 	"strings"
+	"time"
+)
+
+const (
+	defaultBulkIdField  = "_id"
+	defaultBulkEnvelope = "data"
 )
 
 type MongoDbRefResolver struct {
 	uris             map[string]string
 	makeBulkRequests bool
+	bulkIdField      string
+	bulkEnvelope     string
+	maxIdsPerRequest int
+	postBulkRequests bool
+	postIdsKey       string
+	orderedDocuments bool
 }
 
 func NewMongoDbRefResolver(uriMap map[string]string, makeBulkRequests bool) MongoDbRefResolver {
-	return MongoDbRefResolver{uris: uriMap, makeBulkRequests: makeBulkRequests}
+	return MongoDbRefResolver{
+		uris:             uriMap,
+		makeBulkRequests: makeBulkRequests,
+		bulkIdField:      defaultBulkIdField,
+		bulkEnvelope:     defaultBulkEnvelope,
+	}
+}
+
+// WithBulkIdField sets the field of the documents in a bulk response which is matched against the requested ids.
+// Defaults to "_id".
+func (this MongoDbRefResolver) WithBulkIdField(idField string) MongoDbRefResolver {
+	this.bulkIdField = idField
+	return this
+}
+
+// WithBulkEnvelope sets the key of a bulk response which holds the list of documents. Defaults to "data",
+// an empty key means the response body is the list itself.
+func (this MongoDbRefResolver) WithBulkEnvelope(envelope string) MongoDbRefResolver {
+	this.bulkEnvelope = envelope
+	return this
+}
+
+// WithMaxIdsPerRequest splits bulk requests into chunks of at most maxIds ids. Zero means no limit.
+func (this MongoDbRefResolver) WithMaxIdsPerRequest(maxIds int) MongoDbRefResolver {
+	this.maxIdsPerRequest = maxIds
+	return this
+}
+
+// WithBulkPost sends the ids of a bulk request as JSON body {idsKey: [ids...]} to the collection URI
+// instead of appending them to it. An empty key sends the list of ids itself.
+func (this MongoDbRefResolver) WithBulkPost(idsKey string) MongoDbRefResolver {
+	this.postBulkRequests = true
+	this.postIdsKey = idsKey
+	return this
+}
+
+// WithOrderedDocuments resolves references to OrderedMaps, which keep the key order of the documents.
+func (this MongoDbRefResolver) WithOrderedDocuments() MongoDbRefResolver {
+	this.orderedDocuments = true
+	return this
 }
 
 type MongoDBRef struct {
@@ -63,83 +118,338 @@ func (this MongoDbRefResolver) IsReference(t reflect.Value) (Reference, bool) {
 	return reference, true
 }
 
+func (this MongoDbRefResolver) MayBeReference(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct || t.NumField() != 3 {
+		return false
+	}
+	_, hasCollection := t.FieldByName("Collection")
+	_, hasId := t.FieldByName("Id")
+	return hasCollection && hasId
+}
+
 func (this MongoDbRefResolver) GetName() string {
 	return "MongoDbRefResolver"
 }
 
 func (this MongoDbRefResolver) ResolveRef(refs []Reference) map[string]interface{} {
+	return this.ResolveRefContext(context.Background(), refs)
+}
+
+// ResolveRefContext resolves the references like ResolveRef, requests still running when the context is done are
+// cancelled.
+func (this MongoDbRefResolver) ResolveRefContext(ctx context.Context, refs []Reference) map[string]interface{} {
 	if this.makeBulkRequests {
-		return this.resolveWithBulkRequests(refs)
+		return this.resolveWithBulkRequests(ctx, refs)
 	} else {
-		return this.resolveStupid(refs)
+		return this.resolveStupid(ctx, refs)
 	}
 
 }
 
-var makeGetCall = func(uri *url.URL) ([]byte, bool) {
+// HttpStatusError is returned when a resource could not be fetched because the
+// service answered with a status code outside of the 2xx range.
+type HttpStatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+}
+
+func (this HttpStatusError) Error() string {
+	return fmt.Sprintf("%v %v returned status %v", this.Method, this.URL, this.StatusCode)
+}
+
+// ContentTypeError is returned when a service answered with a body that is not JSON.
+type ContentTypeError struct {
+	Method      string
+	URL         string
+	ContentType string
+}
+
+func (this ContentTypeError) Error() string {
+	return fmt.Sprintf("%v %v returned unexpected content type '%v'", this.Method, this.URL, this.ContentType)
+}
+
+// DefaultHTTPTimeout limits requests of the resolvers which are not limited by a resolver or expansion timeout.
+const DefaultHTTPTimeout = 30 * time.Second
+
+var httpClient = &http.Client{Timeout: DefaultHTTPTimeout}
+
+// SetHTTPClient sets the client the resolvers make their requests with, e.g. to change the timeout or transport.
+func SetHTTPClient(client *http.Client) {
+	httpClient = client
+}
+
+var makeGetCall = func(ctx context.Context, uri *url.URL) ([]byte, error) {
+	if uri == nil {
+		return nil, errors.New("no URI given")
+	}
+	request, err := http.NewRequest("GET", uri.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := httpClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return readJsonResponse("GET", uri, response)
+}
+
+var makePostCall = func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error) {
 	if uri == nil {
-		return []byte(""), false
+		return nil, errors.New("no URI given")
+	}
+	request, err := http.NewRequest("POST", uri.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
 	}
-	response, err := http.Get(uri.String())
+	request.Header.Set("Content-Type", "application/json")
+	response, err := httpClient.Do(request.WithContext(ctx))
 	if err != nil {
-		fmt.Println(err)
-		return []byte(""), false
+		return nil, err
 	}
+	return readJsonResponse("POST", uri, response)
+}
 
+func readJsonResponse(method string, uri *url.URL, response *http.Response) ([]byte, error) {
 	defer response.Body.Close()
 	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading content of response body: %v", err)
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, HttpStatusError{Method: method, URL: uri.String(), StatusCode: response.StatusCode}
+	}
+
+	contentType := response.Header.Get("Content-Type")
+	if !isJsonContentType(contentType) {
+		return nil, ContentTypeError{Method: method, URL: uri.String(), ContentType: contentType}
+	}
+
+	return body, nil
+}
 
+// isJsonContentType accepts application/json, any +json suffix type and a missing header.
+func isJsonContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
 	if err != nil {
-		fmt.Println("Error while reading content of response body. It was: ", err)
+		return false
 	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
 
-	return body, true
+// decodeDocument unmarshals a single resolved resource, which has to be a JSON object.
+func decodeDocument(body []byte) (map[string]interface{}, error) {
+	var document map[string]interface{}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return nil, fmt.Errorf("malformed response body: %v", err)
+	}
+	if document == nil {
+		return nil, errors.New("response body did not contain a JSON object")
+	}
+	return document, nil
+}
+
+func decodeOrderedDocument(body []byte) (OrderedMap, error) {
+	var document OrderedMap
+	if err := json.Unmarshal(body, &document); err != nil {
+		return document, fmt.Errorf("malformed response body: %v", err)
+	}
+	return document, nil
 }
 
-func (this *MongoDbRefResolver) resolveStupid(refs []Reference) map[string]interface{} {
+func (this *MongoDbRefResolver) resolveStupid(ctx context.Context, refs []Reference) map[string]interface{} {
 	callResults := make(map[string]interface{})
 
 	for _, ref := range refs {
 		collection := ref.OriginalReference.(MongoDBRef).Collection
 		id := ref.OriginalReference.(MongoDBRef).Id
-		callURL := this.uris[collection] + id
-		url, _ := url.ParseRequestURI(callURL)
+		baseURL, ok := this.uris[collection]
+		if !ok {
+			callResults[id] = fmt.Errorf("no URI configured for collection '%v'", collection)
+			continue
+		}
+		url, err := url.ParseRequestURI(baseURL + id)
+		if err != nil {
+			callResults[id] = err
+			continue
+		}
 
-		responseBytes, ok := makeGetCall(url)
-		if ok {
-			var response map[string]interface{}
-			_ = json.Unmarshal(responseBytes, &response)
-			callResults[id] = response
+		responseBytes, err := makeGetCall(ctx, url)
+		if err != nil {
+			callResults[id] = err
+			continue
 		}
 
+		var response interface{}
+		if this.orderedDocuments {
+			response, err = decodeOrderedDocument(responseBytes)
+		} else {
+			response, err = decodeDocument(responseBytes)
+		}
+		if err != nil {
+			callResults[id] = err
+			continue
+		}
+		callResults[id] = response
 	}
 	return callResults
 }
 
-func (this *MongoDbRefResolver) resolveWithBulkRequests(refs []Reference) map[string]interface{} {
-	perCollectionIds := make(map[string]string)
+func (this *MongoDbRefResolver) resolveWithBulkRequests(ctx context.Context, refs []Reference) map[string]interface{} {
+	perCollectionIds := make(map[string][]string)
 	for _, task := range refs {
 		mongoRef := task.OriginalReference.(MongoDBRef)
-		perCollectionIds[mongoRef.Collection] += task.Id + ","
+		perCollectionIds[mongoRef.Collection] = append(perCollectionIds[mongoRef.Collection], task.Id)
 	}
 
 	callResults := make(map[string]interface{})
-	for collection, idList := range perCollectionIds {
+	for collection, ids := range perCollectionIds {
+		for _, chunk := range chunkIds(ids, this.maxIdsPerRequest) {
+			documents, err := this.fetchBulk(ctx, collection, chunk)
+			for _, id := range chunk {
+				if err != nil {
+					callResults[id] = err
+				} else if document, ok := documents[id]; ok {
+					callResults[id] = document
+				} else {
+					callResults[id] = fmt.Errorf("id '%v' is missing in bulk response of collection '%v'", id, collection)
+				}
+			}
+		}
+	}
+	return callResults
+}
 
-		callURL := this.uris[collection] + idList
-		url, _ := url.ParseRequestURI(callURL)
-		responseBytes, ok := makeGetCall(url)
-		if ok {
-			var response BulkResponseMongoObject
-			var responseData BulkResponseData
+func chunkIds(ids []string, chunkSize int) [][]string {
+	if chunkSize <= 0 || len(ids) <= chunkSize {
+		return [][]string{ids}
+	}
+	var chunks [][]string
+	for len(ids) > chunkSize {
+		chunks = append(chunks, ids[:chunkSize])
+		ids = ids[chunkSize:]
+	}
+	return append(chunks, ids)
+}
 
-			_ = json.Unmarshal(responseBytes, &response)
-			_ = json.Unmarshal(responseBytes, &responseData)
+func (this *MongoDbRefResolver) fetchBulk(ctx context.Context, collection string, ids []string) (map[string]interface{}, error) {
+	baseURL, ok := this.uris[collection]
+	if !ok {
+		return nil, fmt.Errorf("no URI configured for collection '%v'", collection)
+	}
 
-			for index, mongoObject := range response.Data {
-				callResults[mongoObject.Id] = responseData.Data[index]
+	var responseBytes []byte
+	if this.postBulkRequests {
+		url, err := url.ParseRequestURI(baseURL)
+		if err != nil {
+			return nil, err
+		}
+		var body interface{} = ids
+		if this.postIdsKey != "" {
+			body = map[string][]string{this.postIdsKey: ids}
+		}
+		requestBytes, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		responseBytes, err = makePostCall(ctx, url, requestBytes)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		escapedIds := make([]string, len(ids))
+		for i, id := range ids {
+			escapedIds[i] = url.QueryEscape(id)
+		}
+		url, err := url.ParseRequestURI(baseURL + strings.Join(escapedIds, ","))
+		if err != nil {
+			return nil, err
+		}
+		responseBytes, err = makeGetCall(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return this.decodeBulkResponse(responseBytes)
+}
+
+// decodeBulkResponse maps the documents of a bulk response by their id. Documents without a usable id are skipped.
+func (this *MongoDbRefResolver) decodeBulkResponse(body []byte) (map[string]interface{}, error) {
+	var items []json.RawMessage
+	if this.bulkEnvelope == "" {
+		if err := json.Unmarshal(body, &items); err != nil {
+			return nil, fmt.Errorf("malformed bulk response body: %v", err)
+		}
+	} else {
+		var envelope map[string]json.RawMessage
+		if err := json.Unmarshal(body, &envelope); err != nil {
+			return nil, fmt.Errorf("malformed bulk response body: %v", err)
+		}
+		data, ok := envelope[this.bulkEnvelope]
+		if !ok {
+			return nil, fmt.Errorf("bulk response has no '%v' key", this.bulkEnvelope)
+		}
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, fmt.Errorf("malformed bulk response body: %v", err)
+		}
+	}
+
+	documents := make(map[string]interface{})
+	for _, item := range items {
+		id, ok := this.bulkDocumentId(item)
+		if !ok {
+			continue
+		}
+		if this.orderedDocuments {
+			var document OrderedMap
+			if err := json.Unmarshal(item, &document); err == nil {
+				documents[id] = document
 			}
+			continue
+		}
+		var document map[string]interface{}
+		if err := json.Unmarshal(item, &document); err == nil {
+			documents[id] = document
 		}
 	}
-	return callResults
+	return documents, nil
+}
+
+// bulkDocumentId reads the id of a bulk response document. Numbers are kept as they are written, so large ids do not
+// lose precision on their way through float64.
+func (this *MongoDbRefResolver) bulkDocumentId(item json.RawMessage) (string, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(item, &fields); err != nil {
+		return "", false
+	}
+	field, ok := fields[this.bulkIdField]
+	if !ok {
+		return "", false
+	}
+	decoder := json.NewDecoder(bytes.NewReader(field))
+	decoder.UseNumber()
+	var id interface{}
+	if err := decoder.Decode(&id); err != nil {
+		return "", false
+	}
+	return idToString(id)
+}
+
+// idToString converts a decoded JSON id into the form used by references: strings are used as they are,
+// numbers as they are written and extended JSON ObjectIds ({"$oid": "..."}) by their hex value.
+func idToString(id interface{}) (string, bool) {
+	switch id := id.(type) {
+	case string:
+		return id, true
+	case json.Number:
+		return id.String(), true
+	case map[string]interface{}:
+		oid, ok := id["$oid"].(string)
+		return oid, ok
+	}
+	return "", false
 }
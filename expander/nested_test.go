@@ -0,0 +1,80 @@
+package expander
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"reflect"
+	"testing"
+)
+
+type AuthorRef string
+
+type NestedComment struct {
+	Text   string    `json:"text"`
+	Author AuthorRef `json:"author"`
+}
+
+type NestedPost struct {
+	Author   AuthorRef                  `json:"author"`
+	Comments []NestedComment            `json:"comments"`
+	Pages    [][]NestedComment          `json:"pages"`
+	Pinned   *[]NestedComment           `json:"pinned"`
+	Replies  []*NestedComment           `json:"replies"`
+	Reviews  []map[string]AuthorRef     `json:"reviews"`
+	Featured NestedComment              `json:"featured"`
+	Threads  map[string][]NestedComment `json:"threads"`
+}
+
+func TestNestedFilters(t *testing.T) {
+	Convey("It should apply the child filters to nested values:", t, func() {
+		ClearResolvers()
+		alice := map[string]interface{}{"name": "Alice"}
+		AddResolver(NewInMemoryResolver("authors", reflect.TypeOf(AuthorRef("")), map[string]interface{}{"alice": alice}))
+
+		comment := NestedComment{Text: "A comment", Author: "alice"}
+		pinned := []NestedComment{comment}
+		post := NestedPost{
+			Author:   "alice",
+			Comments: []NestedComment{comment},
+			Pages:    [][]NestedComment{{comment}, {comment}},
+			Pinned:   &pinned,
+			Replies:  []*NestedComment{&comment, nil},
+			Reviews:  []map[string]AuthorRef{{"by": "alice"}},
+			Featured: comment,
+			Threads:  map[string][]NestedComment{"main": {comment}},
+		}
+		expanded := map[string]interface{}{"text": "A comment", "author": alice}
+		walked := map[string]interface{}{"text": "A comment", "author": AuthorRef("alice")}
+
+		cases := []struct {
+			name      string
+			expansion string
+			key       string
+			expected  interface{}
+		}{
+			{"slices of structs", "comments(author)", "comments", []interface{}{expanded}},
+			{"slices of slices", "pages(author)", "pages", []interface{}{[]interface{}{expanded}, []interface{}{expanded}}},
+			{"pointers to slices", "pinned(author)", "pinned", []interface{}{expanded}},
+			{"slices of pointers", "replies(author)", "replies", []interface{}{expanded, nil}},
+			{"slices of maps", "reviews(by)", "reviews", []interface{}{map[string]interface{}{"by": alice}}},
+			{"nested structs", "featured(author)", "featured", expanded},
+			{"slices in maps", "threads(main(author))", "threads", map[string]interface{}{"main": []interface{}{expanded}}},
+		}
+
+		for _, c := range cases {
+			Convey("for "+c.name, func() {
+				result := Expand(post, c.expansion, "")
+
+				So(result[c.key], ShouldResemble, c.expected)
+				So(result["author"], ShouldEqual, AuthorRef("alice"))
+			})
+		}
+
+		Convey("but not the filters of the parent", func() {
+			result := Expand(post, "author", "")
+
+			So(result["author"], ShouldResemble, alice)
+			So(result["comments"], ShouldResemble, []interface{}{walked})
+			So(result["featured"], ShouldResemble, walked)
+		})
+	})
+}
@@ -0,0 +1,233 @@
+package expander
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+)
+
+// OrderedMap is a JSON object which keeps the order of its keys when it is encoded. Decoding JSON into an OrderedMap
+// keeps the order of the document, nested objects are decoded into OrderedMaps as well.
+type OrderedMap struct {
+	Keys   []string
+	Values map[string]interface{}
+}
+
+func (this OrderedMap) Get(key string) (interface{}, bool) {
+	value, ok := this.Values[key]
+	return value, ok
+}
+
+func (this OrderedMap) MarshalJSON() ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+	for i, key := range this.Keys {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		encodedKey, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		encodedValue, err := json.Marshal(this.Values[key])
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(encodedKey)
+		buffer.WriteByte(':')
+		buffer.Write(encodedValue)
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
+
+func (this *OrderedMap) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != json.Delim('{') {
+		return errors.New("JSON value is not an object")
+	}
+	object, err := decodeOrderedObject(decoder)
+	if err != nil {
+		return err
+	}
+	*this = object
+	return nil
+}
+
+// decodeOrderedObject decodes the members of an object whose opening brace has been read.
+func decodeOrderedObject(decoder *json.Decoder) (OrderedMap, error) {
+	object := OrderedMap{Values: make(map[string]interface{})}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return object, err
+		}
+		key := token.(string)
+		value, err := decodeOrderedValue(decoder)
+		if err != nil {
+			return object, err
+		}
+		if _, duplicate := object.Values[key]; !duplicate {
+			object.Keys = append(object.Keys, key)
+		}
+		object.Values[key] = value
+	}
+	_, err := decoder.Token()
+	return object, err
+}
+
+func decodeOrderedValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		return decodeOrderedObject(decoder)
+	case json.Delim('['):
+		array := []interface{}{}
+		for decoder.More() {
+			value, err := decodeOrderedValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		_, err := decoder.Token()
+		return array, err
+	}
+	return token, nil
+}
+
+// filter returns a copy holding only the keys selected by the filters, in their order.
+func (this OrderedMap) filter(filters Filters) OrderedMap {
+	values := walkByFilter(this.Values, filters)
+	keys := make([]string, 0, len(values))
+	for _, key := range this.Keys {
+		if _, ok := values[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	return OrderedMap{Keys: keys, Values: values}
+}
+
+// ExpandOrdered works like Expand, but keeps the order of the struct fields of data. Keys added by the key strategy
+// follow the key of their reference. Resolved documents keep their order if the resolver returns OrderedMaps, keys of
+// other maps are sorted.
+func ExpandOrdered(data interface{}, expansion, fields string) OrderedMap {
+	return orderKeys(Expand(data, expansion, fields), reflect.ValueOf(data)).(OrderedMap)
+}
+
+// ExpandArrayOrdered works like ExpandArray, but keeps the order of keys like ExpandOrdered.
+func ExpandArrayOrdered(data interface{}, expansion, fields string) []OrderedMap {
+	expanded := ExpandArray(data, expansion, fields)
+	original := reflect.ValueOf(data)
+	if value, ok := data.(reflect.Value); ok {
+		original = value
+	}
+
+	result := make([]OrderedMap, len(expanded))
+	for i, item := range expanded {
+		result[i] = orderKeys(item, original.Index(i)).(OrderedMap)
+	}
+	return result
+}
+
+// orderKeys converts the maps of the expanded value into OrderedMaps, ordered by the fields of the original struct
+// the value was walked from.
+func orderKeys(value interface{}, original reflect.Value) interface{} {
+	for original.IsValid() && (original.Kind() == reflect.Ptr || original.Kind() == reflect.Interface) {
+		original = original.Elem()
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		var keys []string
+		children := make(map[string]reflect.Value)
+		added := make(map[string]bool)
+		add := func(key string) {
+			if _, ok := value[key]; ok && !added[key] {
+				keys = append(keys, key)
+				added[key] = true
+			}
+		}
+		if original.IsValid() && original.Kind() == reflect.Struct {
+			for _, field := range cachedTypeFields(original.Type()) {
+				key := field.name
+				children[key], _ = fieldByIndex(original, field.index)
+				add(key)
+				if keyStrategy.Sibling != nil {
+					add(keyStrategy.Sibling(key))
+				}
+				if field.hasExpand {
+					add(field.expand.Key)
+				}
+			}
+			add(keyStrategy.NestKey)
+		}
+		if original.IsValid() && original.Kind() == reflect.Slice {
+			if nameIndex, valueIndex, ok := orderedDocumentFields(original.Type()); ok {
+				for i := 0; i < original.Len(); i++ {
+					key := original.Index(i).Field(nameIndex).String()
+					children[key] = original.Index(i).Field(valueIndex)
+					add(key)
+				}
+			}
+		}
+		if original.IsValid() && original.Kind() == reflect.Map {
+			// Go maps have no order, their keys stay sorted but the values keep the order of their own fields
+			for _, k := range original.MapKeys() {
+				if key, ok := mapKey(k); ok {
+					children[key] = original.MapIndex(k)
+				}
+			}
+		}
+		var rest []string
+		for key := range value {
+			if !added[key] {
+				rest = append(rest, key)
+			}
+		}
+		sort.Strings(rest)
+		keys = append(keys, rest...)
+
+		result := OrderedMap{Keys: keys, Values: make(map[string]interface{}, len(value))}
+		for _, key := range keys {
+			result.Values[key] = orderKeys(value[key], children[key])
+		}
+		return result
+	case OrderedMap:
+		result := OrderedMap{Keys: value.Keys, Values: make(map[string]interface{}, len(value.Values))}
+		for key, child := range value.Values {
+			result.Values[key] = orderKeys(child, reflect.Value{})
+		}
+		return result
+	case []map[string]interface{}:
+		result := make([]interface{}, len(value))
+		for i, item := range value {
+			result[i] = orderKeys(item, sliceItem(original, i, len(value)))
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, item := range value {
+			result[i] = orderKeys(item, sliceItem(original, i, len(value)))
+		}
+		return result
+	}
+	return value
+}
+
+// sliceItem returns the item of the original slice the expanded item at index i was walked from, if there is one.
+func sliceItem(original reflect.Value, i int, length int) reflect.Value {
+	if !original.IsValid() || (original.Kind() != reflect.Slice && original.Kind() != reflect.Array) || original.Len() != length {
+		return reflect.Value{}
+	}
+	return original.Index(i)
+}
@@ -0,0 +1,96 @@
+package expander
+
+import (
+	"context"
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type OrderedPost struct {
+	Title    string
+	Views    int
+	AuthorId DBRef `json:"authorId"`
+	Body     string
+}
+
+func TestOrderedMap(t *testing.T) {
+	Convey("It should keep the order of keys of decoded JSON:", t, func() {
+		document := `{"zeta":1,"alpha":{"omega":true,"beta":[{"delta":null,"gamma":"x"}]},"mu":"y"}`
+
+		var ordered OrderedMap
+		err := json.Unmarshal([]byte(document), &ordered)
+		So(err, ShouldBeNil)
+		So(ordered.Keys, ShouldResemble, []string{"zeta", "alpha", "mu"})
+
+		encoded, err := json.Marshal(ordered)
+		So(err, ShouldBeNil)
+		So(string(encoded), ShouldEqual, document)
+
+		err = json.Unmarshal([]byte(`["zeta"]`), &ordered)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("It should write expanded keys in the order of struct fields and resolved documents:", t, func() {
+		ClearResolvers()
+		mockedFn := makeGetCall
+		makeGetCall = func(ctx context.Context, murl *url.URL) ([]byte, error) {
+			if strings.HasSuffix(murl.Path, "/bulk/123") {
+				return []byte(`{"data":[{"_id":"123","zeta":"z","alpha":"a"}]}`), nil
+			}
+			return []byte(`{"name":"A name","age":100,"address":{"street":"s","city":"c"}}`), nil
+		}
+		post := OrderedPost{Title: "A title", Views: 3, AuthorId: DBRef{"authors", MongoId("123"), "a database"}, Body: "A body"}
+
+		Reset(func() {
+			makeGetCall = mockedFn
+			SetKeyStrategy(ReplaceKeys)
+		})
+
+		Convey("Struct fields should keep their order and resolved documents the order of the response", func() {
+			AddResolver(NewMongoDbRefResolver(map[string]string{"authors": "http://some-uri/id/"}, false).WithOrderedDocuments())
+
+			encoded, _ := json.Marshal(ExpandOrdered(post, "*", ""))
+
+			So(string(encoded), ShouldEqual, `{"Title":"A title","Views":3,"authorId":{"name":"A name","age":100,"address":{"street":"s","city":"c"}},"Body":"A body"}`)
+		})
+
+		Convey("Field filters should apply to resolved documents without changing their order", func() {
+			AddResolver(NewMongoDbRefResolver(map[string]string{"authors": "http://some-uri/id/"}, false).WithOrderedDocuments())
+
+			encoded, _ := json.Marshal(ExpandOrdered(post, "*", "Body,authorId(address,name)"))
+
+			So(string(encoded), ShouldEqual, `{"authorId":{"name":"A name","address":{"street":"s","city":"c"}},"Body":"A body"}`)
+		})
+
+		Convey("Sibling keys should follow the key of their reference", func() {
+			SetKeyStrategy(SiblingKeysWithSuffix("Expanded"))
+			AddResolver(NewMongoDbRefResolver(map[string]string{"authors": "http://some-uri/bulk/"}, true).WithOrderedDocuments())
+
+			encoded, _ := json.Marshal(ExpandOrdered(post, "*", ""))
+
+			So(string(encoded), ShouldEqual, `{"Title":"A title","Views":3,"authorId":{"Collection":"authors","Id":"123","Database":"a database"},"authorIdExpanded":{"_id":"123","zeta":"z","alpha":"a"},"Body":"A body"}`)
+		})
+
+		Convey("Structs held by maps should keep the order of their fields", func() {
+			posts := map[string]OrderedPost{"b": post, "a": post}
+
+			result := ExpandOrdered(KindsHolder{posts}, "", "")
+			ordered := result.Values["Value"].(OrderedMap)
+
+			So(ordered.Keys, ShouldResemble, []string{"a", "b"})
+			So(ordered.Values["b"].(OrderedMap).Keys, ShouldResemble, []string{"Title", "Views", "authorId", "Body"})
+		})
+
+		Convey("Arrays should be ordered item by item", func() {
+			AddResolver(NewMongoDbRefResolver(map[string]string{"authors": "http://some-uri/id/"}, false))
+
+			result := ExpandArrayOrdered([]OrderedPost{post, post}, "", "")
+
+			So(len(result), ShouldEqual, 2)
+			So(result[1].Keys, ShouldResemble, []string{"Title", "Views", "authorId", "Body"})
+		})
+	})
+}
@@ -0,0 +1,193 @@
+package expander
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Registry holds resolvers by their name. It can be modified while expansions are running; every expansion works
+// with the resolvers registered when it started.
+type Registry struct {
+	mutex sync.RWMutex
+	set   *resolverSet
+}
+
+// resolverSet is an unmodifiable list of resolvers. It caches which of them may detect references of a type.
+type resolverSet struct {
+	resolvers  []Resolver
+	candidates sync.Map
+}
+
+func newResolverSet(resolvers []Resolver) *resolverSet {
+	return &resolverSet{resolvers: resolvers}
+}
+
+// candidatesFor returns the resolvers which may detect references of the type, in their order.
+func (this *resolverSet) candidatesFor(t reflect.Type) []Resolver {
+	if cached, ok := this.candidates.Load(t); ok {
+		return cached.([]Resolver)
+	}
+	var candidates []Resolver
+	for _, resolver := range this.resolvers {
+		if filter, ok := resolver.(TypeFilter); !ok || filter.MayBeReference(t) {
+			candidates = append(candidates, resolver)
+		}
+	}
+	this.candidates.Store(t, candidates)
+	return candidates
+}
+
+func (this *resolverSet) testForReferences(value reflect.Value) (Reference, Resolver, bool) {
+	var ref Reference
+	if !value.IsValid() {
+		return ref, nil, false
+	}
+	for _, resolver := range this.candidatesFor(value.Type()) {
+		if ref, ok := resolver.IsReference(value); ok {
+			return ref, resolver, true
+		}
+	}
+	return ref, nil, false
+}
+
+func (this *resolverSet) byName(name string) Resolver {
+	for _, resolver := range this.resolvers {
+		if resolver.GetName() == name {
+			return resolver
+		}
+	}
+	return nil
+}
+
+type DuplicateResolverError struct {
+	Name string
+}
+
+func (this DuplicateResolverError) Error() string {
+	return fmt.Sprintf("a resolver named '%v' is already registered", this.Name)
+}
+
+type UnknownResolverError struct {
+	Name string
+}
+
+func (this UnknownResolverError) Error() string {
+	return fmt.Sprintf("no resolver named '%v' is registered", this.Name)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{set: newResolverSet(nil)}
+}
+
+// Register adds the resolver, unless a resolver with the same name is registered already.
+func (this *Registry) Register(resolver Resolver) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.indexOf(resolver.GetName()) >= 0 {
+		return DuplicateResolverError{resolver.GetName()}
+	}
+	this.set = newResolverSet(append(this.copyResolvers(), resolver))
+	return nil
+}
+
+// Replace swaps the registered resolver with the same name for the given one, keeping its position.
+func (this *Registry) Replace(resolver Resolver) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	index := this.indexOf(resolver.GetName())
+	if index < 0 {
+		return UnknownResolverError{resolver.GetName()}
+	}
+	resolvers := this.copyResolvers()
+	resolvers[index] = resolver
+	this.set = newResolverSet(resolvers)
+	return nil
+}
+
+// Put replaces the resolver with the same name or adds the resolver if there is none.
+func (this *Registry) Put(resolver Resolver) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	resolvers := this.copyResolvers()
+	if index := this.indexOf(resolver.GetName()); index >= 0 {
+		resolvers[index] = resolver
+	} else {
+		resolvers = append(resolvers, resolver)
+	}
+	this.set = newResolverSet(resolvers)
+}
+
+func (this *Registry) Remove(name string) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	index := this.indexOf(name)
+	if index < 0 {
+		return UnknownResolverError{name}
+	}
+	resolvers := make([]Resolver, 0, len(this.set.resolvers)-1)
+	resolvers = append(resolvers, this.set.resolvers[:index]...)
+	this.set = newResolverSet(append(resolvers, this.set.resolvers[index+1:]...))
+	return nil
+}
+
+func (this *Registry) Get(name string) (Resolver, bool) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	if index := this.indexOf(name); index >= 0 {
+		return this.set.resolvers[index], true
+	}
+	return nil, false
+}
+
+// List returns a copy of the registered resolvers in the order they are asked to detect references.
+func (this *Registry) List() []Resolver {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	return this.copyResolvers()
+}
+
+func (this *Registry) Clear() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.set = newResolverSet(nil)
+}
+
+// snapshot returns the registered resolvers for one expansion.
+func (this *Registry) snapshot() *resolverSet {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	if this.set == nil {
+		return newResolverSet(nil)
+	}
+	return this.set
+}
+
+func (this *Registry) indexOf(name string) int {
+	if this.set == nil {
+		return -1
+	}
+	for i, resolver := range this.set.resolvers {
+		if resolver.GetName() == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (this *Registry) copyResolvers() []Resolver {
+	if this.set == nil {
+		return make([]Resolver, 0, 1)
+	}
+	resolvers := make([]Resolver, len(this.set.resolvers), len(this.set.resolvers)+1)
+	copy(resolvers, this.set.resolvers)
+	return resolvers
+}
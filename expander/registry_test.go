@@ -0,0 +1,155 @@
+package expander
+
+import (
+	"context"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type countingResolver struct {
+	InMemoryResolver
+	calls *int
+}
+
+func (this countingResolver) IsReference(t reflect.Value) (Reference, bool) {
+	*this.calls++
+	return this.InMemoryResolver.IsReference(t)
+}
+
+func TestRegistry(t *testing.T) {
+	Convey("It should manage resolvers by name:", t, func() {
+		registry := NewRegistry()
+		countries := NewInMemoryResolver("countries", reflect.TypeOf(CountryCode("")), map[string]interface{}{})
+		users := NewInMemoryResolver("users", nil, map[string]interface{}{})
+
+		Convey("Registering a resolver with a taken name should fail", func() {
+			So(registry.Register(countries), ShouldBeNil)
+			So(registry.Register(users), ShouldBeNil)
+
+			err := registry.Register(NewInMemoryResolver("countries", nil, nil))
+
+			So(err, ShouldResemble, DuplicateResolverError{"countries"})
+			So(registry.List(), ShouldResemble, []Resolver{countries, users})
+		})
+
+		Convey("Replacing a resolver should keep its position and fail for unknown names", func() {
+			registry.Register(countries)
+			registry.Register(users)
+			replacement := NewInMemoryResolver("countries", nil, map[string]interface{}{"CH": "Switzerland"})
+
+			So(registry.Replace(replacement), ShouldBeNil)
+			So(registry.Replace(NewInMemoryResolver("groups", nil, nil)), ShouldResemble, UnknownResolverError{"groups"})
+			So(registry.List(), ShouldResemble, []Resolver{replacement, users})
+		})
+
+		Convey("Removed resolvers should not be found anymore", func() {
+			registry.Register(countries)
+			registry.Register(users)
+
+			So(registry.Remove("countries"), ShouldBeNil)
+			So(registry.Remove("countries"), ShouldResemble, UnknownResolverError{"countries"})
+			_, found := registry.Get("countries")
+			So(found, ShouldBeFalse)
+			resolver, found := registry.Get("users")
+			So(found, ShouldBeTrue)
+			So(resolver, ShouldResemble, users)
+		})
+
+		Convey("A listed snapshot should not change when the registry is modified", func() {
+			registry.Register(countries)
+			snapshot := registry.List()
+
+			registry.Put(users)
+			registry.Put(NewInMemoryResolver("countries", nil, nil))
+			registry.Remove("users")
+
+			So(snapshot, ShouldResemble, []Resolver{countries})
+		})
+
+		Convey("Changing a listed resolver should not change the registry", func() {
+			registry.Register(countries)
+			registry.Register(users)
+
+			registry.List()[0] = users
+
+			resolver, _ := registry.Get("countries")
+			So(resolver, ShouldResemble, countries)
+			So(registry.List(), ShouldResemble, []Resolver{countries, users})
+		})
+
+		Convey("Resolvers with a type filter should only be asked for values of their types", func() {
+			ClearResolvers()
+			calls := 0
+			countries := NewInMemoryResolver("countries", reflect.TypeOf(CountryCode("")), map[string]interface{}{"CH": "Switzerland"})
+			AddResolver(countingResolver{countries, &calls})
+
+			result := Expand(Address{Street: "Bahnhofstrasse", Country: "CH", Neighbors: []CountryCode{"CH"}}, "*", "")
+
+			So(result["Country"], ShouldEqual, "Switzerland")
+			So(result["Neighbors"], ShouldResemble, []interface{}{"Switzerland"})
+			So(calls, ShouldEqual, 2)
+		})
+
+		Convey("AddResolver should replace a resolver with the same name", func() {
+			ClearResolvers()
+			AddResolver(NewInMemoryResolver("countries", reflect.TypeOf(CountryCode("")), map[string]interface{}{"CH": "old"}))
+			AddResolver(NewInMemoryResolver("countries", reflect.TypeOf(CountryCode("")), map[string]interface{}{"CH": "new"}))
+
+			result := Expand(Address{Country: "CH"}, "*", "")
+
+			So(len(ListResolvers()), ShouldEqual, 1)
+			So(result["Country"], ShouldEqual, "new")
+		})
+
+		Convey("AddResolver should replace a resolver of the same type with all of its settings", func() {
+			ClearResolvers()
+			mockedFn := makeGetCall
+			defer func() { makeGetCall = mockedFn }()
+			var calledURLs []string
+			makeGetCall = func(ctx context.Context, murl *url.URL) ([]byte, error) {
+				calledURLs = append(calledURLs, murl.String())
+				return []byte(`{"name": "A name"}`), nil
+			}
+			AddResolver(NewMongoDbRefResolver(map[string]string{"users": "http://users/id/"}, false))
+			AddResolver(NewMongoDbRefResolver(map[string]string{"groups": "http://groups/id/"}, false))
+
+			result := Expand(SimpleWithDBRef{Name: "foo", Ref: DBRef{"users", MongoId("1"), "a database"}}, "*", "")
+
+			So(len(ListResolvers()), ShouldEqual, 1)
+			So(result["Ref"], ShouldHaveSameTypeAs, DBRef{})
+			So(calledURLs, ShouldBeEmpty)
+		})
+
+		Convey("Resolvers should be changeable while expansions are running", func() {
+			ClearResolvers()
+			AddResolver(NewInMemoryResolver("countries", reflect.TypeOf(CountryCode("")), map[string]interface{}{"CH": "Switzerland"}))
+
+			results := make([]map[string]interface{}, 10)
+			var wait sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wait.Add(2)
+				go func(i int) {
+					defer wait.Done()
+					results[i] = Expand(Address{Country: "CH"}, "*", "")
+				}(i)
+				go func() {
+					defer wait.Done()
+					AddResolver(NewInMemoryResolver("countries", reflect.TypeOf(CountryCode("")), map[string]interface{}{"CH": "Switzerland"}))
+					RegisterResolver(NewInMemoryResolver("users", nil, nil))
+					RemoveResolver("users")
+				}()
+			}
+			wait.Wait()
+
+			for _, result := range results {
+				So(result["Country"], ShouldEqual, "Switzerland")
+			}
+			resolver, found := GetResolver("countries")
+			So(found, ShouldBeTrue)
+			So(resolver.GetName(), ShouldEqual, "countries")
+		})
+	})
+}
@@ -0,0 +1,239 @@
+package expander
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// sleep and now are variables so tests can replace the clock. sleep returns early when the context is done.
+var sleep = func(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+var now = time.Now
+
+type RetryOptions struct {
+	// MaxAttempts is the number of calls made for a reference, including the first one.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+type CircuitBreakerOptions struct {
+	// FailureThreshold is the number of consecutive failed calls which opens the circuit. Zero disables the breaker.
+	FailureThreshold int
+	// OpenDuration is the time the circuit stays open before a single trial call is let through.
+	OpenDuration time.Duration
+}
+
+// ResilientResolver wraps a Resolver with retries for transient failures and a circuit breaker per collection.
+// References which are not resolved while the circuit is open are mapped to ErrCircuitOpen and therefore
+// handled by the error policy.
+type ResilientResolver struct {
+	resolver Resolver
+	retry    RetryOptions
+	breaker  CircuitBreakerOptions
+
+	mutex    sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	failures  int
+	openUntil time.Time
+	trialCall bool
+}
+
+func NewResilientResolver(resolver Resolver, retry RetryOptions, breaker CircuitBreakerOptions) *ResilientResolver {
+	if retry.MaxAttempts < 1 {
+		retry.MaxAttempts = 1
+	}
+	return &ResilientResolver{
+		resolver: resolver,
+		retry:    retry,
+		breaker:  breaker,
+		circuits: make(map[string]*circuit),
+	}
+}
+
+func (this *ResilientResolver) IsReference(t reflect.Value) (Reference, bool) {
+	return this.resolver.IsReference(t)
+}
+
+func (this *ResilientResolver) MayBeReference(t reflect.Type) bool {
+	filter, ok := this.resolver.(TypeFilter)
+	return !ok || filter.MayBeReference(t)
+}
+
+func (this *ResilientResolver) GetName() string {
+	return this.resolver.GetName()
+}
+
+func (this *ResilientResolver) ResolveRef(refs []Reference) map[string]interface{} {
+	return this.ResolveRefContext(context.Background(), refs)
+}
+
+// ResolveRefContext passes the context on to the wrapped resolver if it takes one, and does not retry once the
+// context is done.
+func (this *ResilientResolver) ResolveRefContext(ctx context.Context, refs []Reference) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	var pending []Reference
+	calledCircuits := make(map[string]bool)
+	for _, ref := range refs {
+		key := circuitKey(ref)
+		allowed, decided := calledCircuits[key]
+		if !decided {
+			allowed = this.allowCall(key)
+			calledCircuits[key] = allowed
+		}
+		if allowed {
+			pending = append(pending, ref)
+		} else {
+			result[ref.Id] = ErrCircuitOpen
+		}
+	}
+
+	failedCircuits := make(map[string]bool)
+
+	for attempt := 1; len(pending) > 0; attempt++ {
+		callResult := resolveRef(ctx, this.resolver, pending)
+
+		var retry []Reference
+		for _, ref := range pending {
+			value, ok := callResult[ref.Id]
+			err, isError := value.(error)
+			if !ok || !isError {
+				if ok {
+					result[ref.Id] = value
+				}
+				continue
+			}
+			result[ref.Id] = err
+			switch {
+			case isTransient(err) && attempt < this.retry.MaxAttempts:
+				retry = append(retry, ref)
+			case isServiceFailure(err):
+				failedCircuits[circuitKey(ref)] = true
+			}
+		}
+
+		pending = retry
+		if len(pending) > 0 {
+			sleep(ctx, this.backoff(attempt))
+		}
+		if ctx.Err() != nil {
+			// references given up on before their last attempt count as failed calls as well
+			for _, ref := range pending {
+				failedCircuits[circuitKey(ref)] = true
+			}
+			break
+		}
+	}
+
+	for key, called := range calledCircuits {
+		if called {
+			this.recordCall(key, !failedCircuits[key])
+		}
+	}
+	return result
+}
+
+// backoff returns the exponential delay after the given attempt, with half of it randomized.
+func (this *ResilientResolver) backoff(attempt int) time.Duration {
+	delay := this.retry.InitialBackoff
+	for i := 1; i < attempt && (this.retry.MaxBackoff <= 0 || delay < this.retry.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if this.retry.MaxBackoff > 0 && delay > this.retry.MaxBackoff {
+		delay = this.retry.MaxBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (this *ResilientResolver) allowCall(key string) bool {
+	if this.breaker.FailureThreshold <= 0 {
+		return true
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	c, ok := this.circuits[key]
+	if !ok || c.failures < this.breaker.FailureThreshold {
+		return true
+	}
+	if now().Before(c.openUntil) || c.trialCall {
+		return false
+	}
+	c.trialCall = true
+	return true
+}
+
+func (this *ResilientResolver) recordCall(key string, success bool) {
+	if this.breaker.FailureThreshold <= 0 {
+		return
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	c, ok := this.circuits[key]
+	if !ok {
+		c = &circuit{}
+		this.circuits[key] = c
+	}
+	c.trialCall = false
+	if success {
+		c.failures = 0
+		return
+	}
+	c.failures++
+	if c.failures >= this.breaker.FailureThreshold {
+		c.openUntil = now().Add(this.breaker.OpenDuration)
+	}
+}
+
+// circuitKey separates the circuits of a resolver by collection where the reference has one.
+func circuitKey(ref Reference) string {
+	if mongoRef, ok := ref.OriginalReference.(MongoDBRef); ok {
+		return mongoRef.Collection
+	}
+	return ""
+}
+
+// isServiceFailure reports whether a failed call counts towards opening the circuit: every failure except requests
+// the service rejected as invalid (4xx other than 429), whether it is worth repeating or not.
+func isServiceFailure(err error) bool {
+	var statusError HttpStatusError
+	if errors.As(err, &statusError) {
+		return statusError.StatusCode < 400 || statusError.StatusCode >= 500 || statusError.StatusCode == 429
+	}
+	return true
+}
+
+// isTransient reports whether a failed call is worth repeating: overloaded or failing services and network errors
+// which are timeouts or temporary. Wrapped errors are unwrapped.
+func isTransient(err error) bool {
+	var statusError HttpStatusError
+	if errors.As(err, &statusError) {
+		return statusError.StatusCode >= 500 || statusError.StatusCode == 429
+	}
+	var netError net.Error
+	if errors.As(err, &netError) {
+		return netError.Timeout() || netError.Temporary()
+	}
+	return false
+}
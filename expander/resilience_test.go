@@ -0,0 +1,186 @@
+package expander
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResilientResolver(t *testing.T) {
+	Convey("It should retry transient failures and stop calling an unhealthy service:", t, func() {
+		var calls int32
+		var failures int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if atomic.AddInt32(&calls, 1) <= atomic.LoadInt32(&failures) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			if r.URL.Path == "/missing" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(`{"Name": "A name"}`))
+		}))
+		defer server.Close()
+
+		var sleeps []time.Duration
+		clock := time.Now()
+		mockedSleep, mockedNow := sleep, now
+		sleep = func(ctx context.Context, d time.Duration) {
+			sleeps = append(sleeps, d)
+			clock = clock.Add(d)
+		}
+		now = func() time.Time {
+			return clock
+		}
+
+		uris := map[string]string{"a collection": server.URL + "/"}
+		ref := Reference{Id: "123", OriginalReference: MongoDBRef{Id: "123", Collection: "a collection"}}
+		retry := RetryOptions{MaxAttempts: 3, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+		Convey("Transient failures should be retried with growing backoff", func() {
+			failures = 2
+			resolver := NewResilientResolver(NewMongoDbRefResolver(uris, false), retry, CircuitBreakerOptions{})
+
+			result := resolver.ResolveRef([]Reference{ref})
+
+			So(result["123"].(map[string]interface{})["Name"], ShouldEqual, "A name")
+			So(calls, ShouldEqual, 3)
+			So(len(sleeps), ShouldEqual, 2)
+			So(sleeps[0], ShouldBeBetweenOrEqual, 50*time.Millisecond, 100*time.Millisecond)
+			So(sleeps[1], ShouldBeBetweenOrEqual, 100*time.Millisecond, 200*time.Millisecond)
+		})
+
+		Convey("Permanent failures should not be retried", func() {
+			missing := Reference{Id: "missing", OriginalReference: MongoDBRef{Id: "missing", Collection: "a collection"}}
+			resolver := NewResilientResolver(NewMongoDbRefResolver(uris, false), retry, CircuitBreakerOptions{})
+
+			result := resolver.ResolveRef([]Reference{missing})
+
+			So(result["missing"], ShouldHaveSameTypeAs, HttpStatusError{})
+			So(calls, ShouldEqual, 1)
+		})
+
+		Convey("Only overloaded services, timeouts and temporary network errors should be transient", func() {
+			cases := []struct {
+				err       error
+				transient bool
+			}{
+				{HttpStatusError{StatusCode: http.StatusServiceUnavailable}, true},
+				{HttpStatusError{StatusCode: http.StatusTooManyRequests}, true},
+				{HttpStatusError{StatusCode: http.StatusNotFound}, false},
+				{fmt.Errorf("fetching: %w", HttpStatusError{StatusCode: http.StatusBadGateway}), true},
+				{&url.Error{Op: "Get", URL: "http://some-uri", Err: fakeNetError{timeout: true}}, true},
+				{&url.Error{Op: "Get", URL: "http://some-uri", Err: &net.OpError{Op: "read", Err: fakeNetError{temporary: true}}}, true},
+				{&url.Error{Op: "Get", URL: "http://some-uri", Err: fakeNetError{}}, false},
+				{&url.Error{Op: "Get", URL: "http://some-uri", Err: errors.New("unsupported protocol scheme")}, false},
+				{&url.Error{Op: "Get", URL: "http://some-uri", Err: context.Canceled}, false},
+				{errors.New("malformed body"), false},
+			}
+
+			for _, c := range cases {
+				So(isTransient(c.err), ShouldEqual, c.transient)
+			}
+		})
+
+		Convey("The circuit should open after consecutive failures and close again after a successful trial", func() {
+			failures = 4
+			breaker := CircuitBreakerOptions{FailureThreshold: 2, OpenDuration: time.Minute}
+			resolver := NewResilientResolver(NewMongoDbRefResolver(uris, false), RetryOptions{MaxAttempts: 2}, breaker)
+
+			resolver.ResolveRef([]Reference{ref})
+			resolver.ResolveRef([]Reference{ref})
+			So(calls, ShouldEqual, 4)
+
+			result := resolver.ResolveRef([]Reference{ref})
+			So(result["123"], ShouldEqual, ErrCircuitOpen)
+			So(calls, ShouldEqual, 4)
+
+			clock = clock.Add(time.Minute)
+			result = resolver.ResolveRef([]Reference{ref})
+			So(result["123"].(map[string]interface{})["Name"], ShouldEqual, "A name")
+			So(calls, ShouldEqual, 5)
+		})
+
+		Convey("Failures which are not retried should open the circuit as well", func() {
+			down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			down.Close()
+			breaker := CircuitBreakerOptions{FailureThreshold: 2, OpenDuration: time.Minute}
+			resolver := NewResilientResolver(NewMongoDbRefResolver(map[string]string{"a collection": down.URL + "/"}, false), retry, breaker)
+
+			result := resolver.ResolveRef([]Reference{ref})
+			So(isTransient(result["123"].(error)), ShouldBeFalse)
+			So(len(sleeps), ShouldEqual, 0)
+
+			resolver.ResolveRef([]Reference{ref})
+			So(resolver.ResolveRef([]Reference{ref})["123"], ShouldEqual, ErrCircuitOpen)
+		})
+
+		Convey("Rejected requests should not open the circuit", func() {
+			missing := Reference{Id: "missing", OriginalReference: MongoDBRef{Id: "missing", Collection: "a collection"}}
+			breaker := CircuitBreakerOptions{FailureThreshold: 1, OpenDuration: time.Minute}
+			resolver := NewResilientResolver(NewMongoDbRefResolver(uris, false), retry, breaker)
+
+			resolver.ResolveRef([]Reference{missing})
+			result := resolver.ResolveRef([]Reference{ref})
+
+			So(result["123"].(map[string]interface{})["Name"], ShouldEqual, "A name")
+		})
+
+		Convey("Retries cut short by the context should stop waiting and count as failures", func() {
+			failures = 100
+			sleep = mockedSleep
+			slowRetry := RetryOptions{MaxAttempts: 3, InitialBackoff: 400 * time.Millisecond}
+			breaker := CircuitBreakerOptions{FailureThreshold: 1, OpenDuration: time.Minute}
+			resolver := NewResilientResolver(NewMongoDbRefResolver(uris, false), slowRetry, breaker)
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			started := time.Now()
+			resolver.ResolveRefContext(ctx, []Reference{ref})
+
+			So(time.Since(started), ShouldBeLessThan, 150*time.Millisecond)
+			So(calls, ShouldEqual, 1)
+			So(resolver.ResolveRef([]Reference{ref})["123"], ShouldEqual, ErrCircuitOpen)
+		})
+
+		Convey("An open circuit should be handled by the error policy during expansion", func() {
+			failures = 100
+			ClearResolvers()
+			breaker := CircuitBreakerOptions{FailureThreshold: 1, OpenDuration: time.Minute}
+			AddResolver(NewResilientResolver(NewMongoDbRefResolver(uris, false), RetryOptions{}, breaker))
+			SetErrorPolicy(ErrorObject)
+			simple := SimpleWithDBRef{Name: "foo", Ref: DBRef{"a collection", MongoId("123"), "a database"}}
+
+			Expand(simple, "*", "")
+			result := Expand(simple, "*", "")
+
+			So(result["Ref"].(map[string]interface{})["error"], ShouldEqual, ErrCircuitOpen.Error())
+			So(calls, ShouldEqual, 1)
+
+			SetErrorPolicy(KeepReference)
+			ClearResolvers()
+		})
+
+		Reset(func() {
+			sleep, now = mockedSleep, mockedNow
+		})
+	})
+}
+
+type fakeNetError struct {
+	timeout, temporary bool
+}
+
+func (this fakeNetError) Error() string   { return "network error" }
+func (this fakeNetError) Timeout() bool   { return this.timeout }
+func (this fakeNetError) Temporary() bool { return this.temporary }
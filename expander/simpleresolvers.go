@@ -0,0 +1,74 @@
+package expander
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// ResolverFunc builds a Resolver from a detection predicate and a batch lookup function.
+type ResolverFunc struct {
+	Name   string
+	Detect func(reflect.Value) (Reference, bool)
+	Lookup func([]Reference) map[string]interface{}
+}
+
+func NewResolverFunc(name string, detect func(reflect.Value) (Reference, bool), lookup func([]Reference) map[string]interface{}) ResolverFunc {
+	return ResolverFunc{Name: name, Detect: detect, Lookup: lookup}
+}
+
+func (this ResolverFunc) IsReference(t reflect.Value) (Reference, bool) {
+	return this.Detect(t)
+}
+
+func (this ResolverFunc) ResolveRef(refs []Reference) map[string]interface{} {
+	return this.Lookup(refs)
+}
+
+func (this ResolverFunc) GetName() string {
+	return this.Name
+}
+
+// InMemoryResolver expands every value of the given reference type with the entry of a static lookup table,
+// e.g. a CountryCode with the country it stands for. The id of a reference is the value formatted with %v.
+type InMemoryResolver struct {
+	name          string
+	referenceType reflect.Type
+	values        map[string]interface{}
+}
+
+func NewInMemoryResolver(name string, referenceType reflect.Type, values map[string]interface{}) InMemoryResolver {
+	copied := make(map[string]interface{}, len(values))
+	for id, value := range values {
+		copied[id] = value
+	}
+	return InMemoryResolver{name: name, referenceType: referenceType, values: copied}
+}
+
+func (this InMemoryResolver) IsReference(t reflect.Value) (Reference, bool) {
+	var reference Reference
+	if !t.IsValid() || t.Type() != this.referenceType {
+		return reference, false
+	}
+
+	reference.OriginalReference = t.Interface()
+	reference.Id = fmt.Sprintf("%v", reference.OriginalReference)
+	return reference, true
+}
+
+func (this InMemoryResolver) MayBeReference(t reflect.Type) bool {
+	return t == this.referenceType
+}
+
+func (this InMemoryResolver) ResolveRef(refs []Reference) map[string]interface{} {
+	result := make(map[string]interface{})
+	for _, ref := range refs {
+		if value, ok := this.values[ref.Id]; ok {
+			result[ref.Id] = value
+		}
+	}
+	return result
+}
+
+func (this InMemoryResolver) GetName() string {
+	return this.name
+}
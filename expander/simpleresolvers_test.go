@@ -0,0 +1,80 @@
+package expander
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type CountryCode string
+
+type Address struct {
+	Street    string
+	Country   CountryCode
+	Neighbors []CountryCode
+}
+
+func TestSimpleResolvers(t *testing.T) {
+	Convey("It should expand references with resolvers built from functions and maps:", t, func() {
+		ClearResolvers()
+		countries := map[string]interface{}{
+			"CH": map[string]interface{}{"name": "Switzerland"},
+			"DE": map[string]interface{}{"name": "Germany"},
+		}
+
+		Convey("An in-memory resolver should expand values of its reference type", func() {
+			AddResolver(NewInMemoryResolver("countries", reflect.TypeOf(CountryCode("")), countries))
+			address := Address{Street: "Bahnhofstrasse", Country: "CH", Neighbors: []CountryCode{"DE", "XX"}}
+
+			result := Expand(address, "*", "")
+
+			So(result["Street"], ShouldEqual, address.Street)
+			So(result["Country"], ShouldResemble, countries["CH"])
+			neighbors := result["Neighbors"].([]interface{})
+			So(neighbors[0], ShouldResemble, countries["DE"])
+			So(neighbors[1], ShouldEqual, CountryCode("XX"))
+		})
+
+		Convey("An in-memory resolver should only expand fields given in the expansion filter", func() {
+			AddResolver(NewInMemoryResolver("countries", reflect.TypeOf(CountryCode("")), countries))
+			address := Address{Street: "Bahnhofstrasse", Country: "CH", Neighbors: []CountryCode{"DE"}}
+
+			result := Expand(address, "Country", "")
+
+			So(result["Country"], ShouldResemble, countries["CH"])
+			So(result["Neighbors"].([]interface{})[0], ShouldEqual, CountryCode("DE"))
+		})
+
+		Convey("A function resolver should use the given predicate and resolve all references in one batch", func() {
+			var batches [][]Reference
+			detect := func(v reflect.Value) (Reference, bool) {
+				if v.Kind() == reflect.String && strings.HasPrefix(v.String(), "user:") {
+					return Reference{Id: strings.TrimPrefix(v.String(), "user:"), OriginalReference: v.String()}, true
+				}
+				return Reference{}, false
+			}
+			lookup := func(refs []Reference) map[string]interface{} {
+				batches = append(batches, refs)
+				result := make(map[string]interface{})
+				for _, ref := range refs {
+					result[ref.Id] = map[string]interface{}{"id": ref.Id}
+				}
+				return result
+			}
+			AddResolver(NewResolverFunc("users", detect, lookup))
+			info := SimpleWithLinks{Name: "user:1", Members: []Link{{Ref: "user:2"}}}
+
+			result := Expand(info, "*", "")
+
+			So(result["Name"], ShouldResemble, map[string]interface{}{"id": "1"})
+			So(result["Members"].([]interface{})[0].(map[string]interface{})["ref"], ShouldResemble, map[string]interface{}{"id": "2"})
+			So(len(batches), ShouldEqual, 1)
+			So(len(batches[0]), ShouldEqual, 2)
+		})
+
+		Reset(func() {
+			ClearResolvers()
+		})
+	})
+}
@@ -0,0 +1,158 @@
+package expander
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// SQLTable describes where the rows for one reference type are stored.
+type SQLTable struct {
+	Table     string
+	KeyColumn string
+	Columns   []string
+}
+
+// PlaceholderFormat returns the bind parameter for the argument at the given position, starting with 1.
+type PlaceholderFormat func(position int) string
+
+var QuestionMarkPlaceholders PlaceholderFormat = func(position int) string {
+	return "?"
+}
+
+var DollarPlaceholders PlaceholderFormat = func(position int) string {
+	return fmt.Sprintf("$%v", position)
+}
+
+// SQLResolver expands foreign keys with the rows they point to. Every reference type is mapped to a table, so a
+// field of type AuthorId can be expanded with the row of the authors table. Rows are resolved as maps of the selected
+// columns, with one query per table and batch.
+type SQLResolver struct {
+	name         string
+	db           *sql.DB
+	tables       map[reflect.Type]SQLTable
+	placeholders PlaceholderFormat
+}
+
+type sqlReference struct {
+	Type reflect.Type
+	Key  interface{}
+}
+
+func NewSQLResolver(name string, db *sql.DB, tables map[reflect.Type]SQLTable) SQLResolver {
+	return SQLResolver{name: name, db: db, tables: tables, placeholders: QuestionMarkPlaceholders}
+}
+
+// WithPlaceholders sets the bind parameter syntax of the driver, e.g. DollarPlaceholders for Postgres.
+func (this SQLResolver) WithPlaceholders(placeholders PlaceholderFormat) SQLResolver {
+	this.placeholders = placeholders
+	return this
+}
+
+func (this SQLResolver) IsReference(t reflect.Value) (Reference, bool) {
+	var reference Reference
+	if !t.IsValid() {
+		return reference, false
+	}
+	table, ok := this.tables[t.Type()]
+	if !ok {
+		return reference, false
+	}
+
+	// keys of different tables may be equal, so ids are unique per table only
+	reference.OriginalReference = sqlReference{Type: t.Type(), Key: t.Interface()}
+	reference.Id = UniqueKey(table.Table, fmt.Sprintf("%v", t.Interface()))
+	return reference, true
+}
+
+func (this SQLResolver) MayBeReference(t reflect.Type) bool {
+	_, ok := this.tables[t]
+	return ok
+}
+
+func (this SQLResolver) GetName() string {
+	return this.name
+}
+
+func (this SQLResolver) ResolveRef(refs []Reference) map[string]interface{} {
+	return this.ResolveRefContext(context.Background(), refs)
+}
+
+// ResolveRefContext resolves the references like ResolveRef, queries still running when the context is done are
+// cancelled.
+func (this SQLResolver) ResolveRefContext(ctx context.Context, refs []Reference) map[string]interface{} {
+	perTypeRefs := make(map[reflect.Type][]sqlReference)
+	perTypeIds := make(map[reflect.Type][]string)
+	seen := make(map[string]bool)
+	for _, ref := range refs {
+		sqlRef := ref.OriginalReference.(sqlReference)
+		if seen[ref.Id] {
+			continue
+		}
+		seen[ref.Id] = true
+		perTypeRefs[sqlRef.Type] = append(perTypeRefs[sqlRef.Type], sqlRef)
+		perTypeIds[sqlRef.Type] = append(perTypeIds[sqlRef.Type], ref.Id)
+	}
+
+	callResults := make(map[string]interface{})
+	for referenceType, sqlRefs := range perTypeRefs {
+		keys := make([]interface{}, len(sqlRefs))
+		for i, sqlRef := range sqlRefs {
+			keys[i] = sqlRef.Key
+		}
+		rows, err := this.queryRows(ctx, this.tables[referenceType], keys)
+		for i, id := range perTypeIds[referenceType] {
+			if err != nil {
+				callResults[id] = err
+			} else if row, ok := rows[fmt.Sprintf("%v", sqlRefs[i].Key)]; ok {
+				callResults[id] = row
+			}
+		}
+	}
+	return callResults
+}
+
+func (this SQLResolver) queryRows(ctx context.Context, table SQLTable, keys []interface{}) (map[string]interface{}, error) {
+	columns := []string{table.KeyColumn}
+	for _, column := range table.Columns {
+		if column != table.KeyColumn {
+			columns = append(columns, column)
+		}
+	}
+	placeholders := make([]string, len(keys))
+	for i := range keys {
+		placeholders[i] = this.placeholders(i + 1)
+	}
+	query := fmt.Sprintf("SELECT %v FROM %v WHERE %v IN (%v)",
+		strings.Join(columns, ", "), table.Table, table.KeyColumn, strings.Join(placeholders, ", "))
+
+	rows, err := this.db.QueryContext(ctx, query, keys...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]interface{})
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+
+		row := make(map[string]interface{})
+		for i, column := range columns {
+			if bytes, ok := values[i].([]byte); ok {
+				values[i] = string(bytes)
+			}
+			row[column] = values[i]
+		}
+		result[fmt.Sprintf("%v", values[0])] = row
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,212 @@
+package expander
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// stubDriver answers "SELECT columns FROM table WHERE key IN (...)" from in-memory tables and records the queries.
+type stubDriver struct {
+	tables  map[string][]map[string]driver.Value
+	queries []string
+}
+
+type stubConn struct {
+	driver *stubDriver
+}
+
+type stubStmt struct {
+	driver *stubDriver
+	query  string
+}
+
+type stubRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+var stub = &stubDriver{}
+
+func init() {
+	sql.Register("expanderstub", stub)
+}
+
+func (this *stubDriver) Open(name string) (driver.Conn, error) {
+	return stubConn{this}, nil
+}
+
+func (this stubConn) Prepare(query string) (driver.Stmt, error) {
+	return stubStmt{this.driver, query}, nil
+}
+
+func (this stubConn) Close() error {
+	return nil
+}
+
+func (this stubConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+func (this stubStmt) Close() error {
+	return nil
+}
+
+func (this stubStmt) NumInput() int {
+	return -1
+}
+
+func (this stubStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("exec is not supported")
+}
+
+func (this stubStmt) Query(args []driver.Value) (driver.Rows, error) {
+	this.driver.queries = append(this.driver.queries, this.query)
+
+	var columnList, table, key string
+	_, err := fmt.Sscanf(strings.Replace(this.query, ", ", ",", -1), "SELECT %s FROM %s WHERE %s IN", &columnList, &table, &key)
+	if err != nil {
+		return nil, err
+	}
+	rows, ok := this.driver.tables[table]
+	if !ok {
+		return nil, fmt.Errorf("no such table: %v", table)
+	}
+
+	result := &stubRows{columns: strings.Split(columnList, ",")}
+	for _, row := range rows {
+		for _, arg := range args {
+			if fmt.Sprintf("%v", row[key]) == fmt.Sprintf("%v", arg) {
+				var values []driver.Value
+				for _, column := range result.columns {
+					values = append(values, row[column])
+				}
+				result.rows = append(result.rows, values)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (this *stubRows) Columns() []string {
+	return this.columns
+}
+
+func (this *stubRows) Close() error {
+	return nil
+}
+
+func (this *stubRows) Next(dest []driver.Value) error {
+	if len(this.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, this.rows[0])
+	this.rows = this.rows[1:]
+	return nil
+}
+
+type AuthorId int64
+
+type BookId int64
+
+type Review struct {
+	Author AuthorId
+	Book   BookId
+}
+
+type TaggedBook struct {
+	AuthorId  AuthorId   `json:"authorId" expand:"author,resolver=authors"`
+	EditorIds []AuthorId `json:"editorIds" expand:"editors,resolver=authors"`
+}
+
+type Book struct {
+	Title    string
+	Author   AuthorId
+	CoAuthor []AuthorId
+}
+
+func TestSQLResolver(t *testing.T) {
+	Convey("It should expand foreign keys with the rows they point to:", t, func() {
+		ClearResolvers()
+		stub.queries = nil
+		stub.tables = map[string][]map[string]driver.Value{
+			"authors": {
+				{"id": int64(1), "name": []byte("Ann"), "email": "ann@example.com"},
+				{"id": int64(2), "name": []byte("Bob"), "email": "bob@example.com"},
+				{"id": int64(3), "name": []byte("Eve"), "email": "eve@example.com"},
+			},
+		}
+		db, _ := sql.Open("expanderstub", "")
+		tables := map[reflect.Type]SQLTable{
+			reflect.TypeOf(AuthorId(0)): {Table: "authors", KeyColumn: "id", Columns: []string{"name"}},
+		}
+
+		Convey("All references of a type should be resolved with a single query", func() {
+			AddResolver(NewSQLResolver("authors", db, tables))
+			book := Book{Title: "A title", Author: 1, CoAuthor: []AuthorId{2, 1, 4}}
+
+			result := Expand(book, "*", "")
+
+			So(result["Author"], ShouldResemble, map[string]interface{}{"id": int64(1), "name": "Ann"})
+			coAuthors := result["CoAuthor"].([]interface{})
+			So(coAuthors[0], ShouldResemble, map[string]interface{}{"id": int64(2), "name": "Bob"})
+			So(coAuthors[1], ShouldResemble, result["Author"])
+			So(coAuthors[2], ShouldEqual, AuthorId(4))
+			So(stub.queries, ShouldResemble, []string{"SELECT id, name FROM authors WHERE id IN (?, ?, ?)"})
+		})
+
+		Convey("Tagged foreign keys should be expanded next to their key", func() {
+			AddResolver(NewSQLResolver("authors", db, tables))
+
+			result := Expand(TaggedBook{AuthorId: 1, EditorIds: []AuthorId{2, 3}}, "*", "")
+
+			So(result["authorId"], ShouldEqual, 1)
+			So(result["author"], ShouldResemble, map[string]interface{}{"id": int64(1), "name": "Ann"})
+			So(result["editors"], ShouldResemble, []interface{}{
+				map[string]interface{}{"id": int64(2), "name": "Bob"},
+				map[string]interface{}{"id": int64(3), "name": "Eve"},
+			})
+			So(len(stub.queries), ShouldEqual, 1)
+		})
+
+		Convey("The placeholder syntax should be configurable", func() {
+			AddResolver(NewSQLResolver("authors", db, tables).WithPlaceholders(DollarPlaceholders))
+
+			Expand(Book{Author: 1, CoAuthor: []AuthorId{2}}, "*", "")
+
+			So(stub.queries, ShouldResemble, []string{"SELECT id, name FROM authors WHERE id IN ($1, $2)"})
+		})
+
+		Convey("A failing query should be reported for every reference", func() {
+			tables[reflect.TypeOf(AuthorId(0))] = SQLTable{Table: "writers", KeyColumn: "id"}
+			resolver := NewSQLResolver("authors", db, tables)
+			ref, _ := resolver.IsReference(reflect.ValueOf(AuthorId(1)))
+
+			result := resolver.ResolveRef([]Reference{ref})
+
+			So(result[ref.Id], ShouldImplement, (*error)(nil))
+		})
+
+		Convey("Equal keys of different tables should be resolved with the rows of their own table", func() {
+			stub.tables["books"] = []map[string]driver.Value{{"id": int64(1), "title": "A book"}}
+			tables[reflect.TypeOf(BookId(0))] = SQLTable{Table: "books", KeyColumn: "id", Columns: []string{"title"}}
+			AddResolver(NewSQLResolver("library", db, tables))
+
+			result := Expand(Review{Author: 1, Book: 1}, "*", "")
+
+			So(result["Author"], ShouldResemble, map[string]interface{}{"id": int64(1), "name": "Ann"})
+			So(result["Book"], ShouldResemble, map[string]interface{}{"id": int64(1), "title": "A book"})
+		})
+
+		Reset(func() {
+			db.Close()
+			ClearResolvers()
+		})
+	})
+}
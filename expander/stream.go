@@ -0,0 +1,107 @@
+package expander
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+const DefaultChunkSize = 100
+
+// ArrayEncoder expands large arrays chunk by chunk and writes them as a JSON array, so only one chunk is held in
+// memory. The references of all items of a chunk are resolved together; the expansion timeout applies per chunk.
+type ArrayEncoder struct {
+	writer    io.Writer
+	chunkSize int
+}
+
+func NewArrayEncoder(writer io.Writer) ArrayEncoder {
+	return ArrayEncoder{writer: writer, chunkSize: DefaultChunkSize}
+}
+
+func (this ArrayEncoder) WithChunkSize(chunkSize int) ArrayEncoder {
+	if chunkSize < 1 {
+		chunkSize = 1
+	}
+	this.chunkSize = chunkSize
+	return this
+}
+
+// Encode writes the expanded items of data, which has to be a slice or array, and flushes the writer after every
+// chunk if it is an http.Flusher. Writing stops at the first error.
+func (this ArrayEncoder) Encode(data interface{}, expansion, fields string) (ExpansionReport, error) {
+	var report ExpansionReport
+
+	expansionFilter, fieldFilter, recursiveExpansion, err := resolveFilters(expansion, fields)
+	if err != nil {
+		expansionFilter = Filters{}
+		fieldFilter = Filters{}
+		fmt.Printf("Warning: Filter was not correct, expansionFilter: '%v' fieldFilter: '%v', error: %v \n", expansion, fields, err)
+	}
+
+	v := reflect.ValueOf(data)
+	if value, ok := data.(reflect.Value); ok {
+		v = value
+	}
+	if !v.IsValid() || (v.Kind() != reflect.Slice && v.Kind() != reflect.Array) {
+		return report, fmt.Errorf("can not encode %T as array", data)
+	}
+
+	if _, err := io.WriteString(this.writer, "["); err != nil {
+		return report, err
+	}
+	resolvers := registry.snapshot()
+	for start := 0; start < v.Len(); start += this.chunkSize {
+		end := start + this.chunkSize
+		if end > v.Len() {
+			end = v.Len()
+		}
+
+		items, timedOut := expandChunk(v, start, end, expansionFilter, fieldFilter, recursiveExpansion, resolvers, expansionDeadline())
+		report.TimedOut = append(report.TimedOut, timedOut...)
+
+		for i, item := range items {
+			content, err := json.Marshal(item)
+			if err != nil {
+				return report, err
+			}
+			if start+i > 0 {
+				content = append([]byte(","), content...)
+			}
+			if _, err := this.writer.Write(content); err != nil {
+				return report, err
+			}
+		}
+		if flusher, ok := this.writer.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+	_, err = io.WriteString(this.writer, "]")
+	return report, err
+}
+
+// expandChunk expands the items from start to end of the slice with one call per resolver.
+func expandChunk(v reflect.Value, start, end int, expansionFilter, fieldFilter Filters, recursive bool, resolvers *resolverSet, deadline time.Time) ([]map[string]interface{}, []Reference) {
+	resolveTasks := []ExpansionTask{}
+
+	items := make([]map[string]interface{}, 0, end-start)
+	for i := start; i < end; i++ {
+		walkStateHolder := WalkStateHolder{&resolveTasks, v.Index(i).Interface(), resolvers}
+		first := len(resolveTasks)
+		items = append(items, walkByExpansion(v.Index(i), walkStateHolder, expansionFilter, recursive))
+		for j := first; j < len(resolveTasks); j++ {
+			if relative, ok := resolvers.byName(resolveTasks[j].Resolver).(documentRelative); ok {
+				resolveTasks[j].Reference = relative.inDocument(resolveTasks[j].Reference, i)
+			}
+		}
+	}
+	timedOut := executeExpansionTasks(resolveTasks, resolvers.resolvers, deadline)
+
+	for i := range items {
+		items[i] = walkByFilter(applyKeyStrategy(items[i]), fieldFilter)
+	}
+	return items, timedOut
+}
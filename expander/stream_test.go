@@ -0,0 +1,106 @@
+package expander
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"reflect"
+	"testing"
+)
+
+type failingWriter struct {
+	writes int
+}
+
+func (this *failingWriter) Write(p []byte) (int, error) {
+	this.writes++
+	if this.writes > 2 {
+		return 0, errors.New("connection reset")
+	}
+	return len(p), nil
+}
+
+func TestArrayEncoder(t *testing.T) {
+	Convey("It should stream expanded arrays as JSON:", t, func() {
+		ClearResolvers()
+		var batches [][]Reference
+		countries := NewInMemoryResolver("countries", reflect.TypeOf(CountryCode("")), map[string]interface{}{
+			"CH": map[string]interface{}{"name": "Switzerland"},
+			"DE": map[string]interface{}{"name": "Germany"},
+		})
+		AddResolver(NewResolverFunc("countries", countries.IsReference, func(refs []Reference) map[string]interface{} {
+			batches = append(batches, refs)
+			return countries.ResolveRef(refs)
+		}))
+		addresses := []Address{
+			{Street: "Bahnhofstrasse", Country: "CH"},
+			{Street: "Unter den Linden", Country: "DE"},
+			{Street: "Paradeplatz", Country: "CH"},
+		}
+
+		Convey("The output should be the JSON of the expanded array", func() {
+			var buffer bytes.Buffer
+
+			_, err := NewArrayEncoder(&buffer).Encode(addresses, "Country", "Street,Country")
+
+			So(err, ShouldBeNil)
+			expected, _ := json.Marshal(ExpandArray(addresses, "Country", "Street,Country"))
+			So(buffer.String(), ShouldEqual, string(expected))
+		})
+
+		Convey("References should be resolved with one call per chunk", func() {
+			var buffer bytes.Buffer
+
+			NewArrayEncoder(&buffer).WithChunkSize(2).Encode(addresses, "*", "")
+
+			So(len(batches), ShouldEqual, 2)
+			So(len(batches[0]), ShouldEqual, 2)
+			So(len(batches[1]), ShouldEqual, 1)
+		})
+
+		Convey("An empty slice should be written as empty array", func() {
+			var buffer bytes.Buffer
+
+			_, err := NewArrayEncoder(&buffer).Encode([]Address{}, "*", "")
+
+			So(err, ShouldBeNil)
+			So(buffer.String(), ShouldEqual, "[]")
+		})
+
+		Convey("Data which is not an array should be refused", func() {
+			var buffer bytes.Buffer
+
+			_, err := NewArrayEncoder(&buffer).Encode(addresses[0], "*", "")
+
+			So(err, ShouldNotBeNil)
+			So(buffer.Len(), ShouldEqual, 0)
+		})
+
+		Convey("Encoding should stop at the first write error", func() {
+			writer := &failingWriter{}
+
+			_, err := NewArrayEncoder(writer).WithChunkSize(1).Encode(addresses, "*", "")
+
+			So(err, ShouldNotBeNil)
+			So(writer.writes, ShouldEqual, 3)
+			So(len(batches), ShouldEqual, 2)
+		})
+	})
+
+	Convey("It should resolve local JSON references of every document in a chunk against that document:", t, func() {
+		ClearResolvers()
+		AddResolver(NewJSONReferenceResolver(NewFileLoader(".")))
+		var documents []map[string]interface{}
+		json.Unmarshal([]byte(`[
+			{"definitions": {"x": {"v": "first"}}, "item": {"$ref": "#/definitions/x"}},
+			{"definitions": {"x": {"v": "second"}}, "item": {"$ref": "#/definitions/x"}}
+		]`), &documents)
+		var buffer bytes.Buffer
+
+		_, err := NewArrayEncoder(&buffer).WithChunkSize(2).Encode(documents, "item", "item")
+
+		So(err, ShouldBeNil)
+		So(buffer.String(), ShouldEqual, `[{"item":{"v":"first"}},{"item":{"v":"second"}}]`)
+	})
+}